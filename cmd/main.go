@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -88,6 +89,17 @@ func main() {
 
 	<-stop
 	logger.Info("Shutting down server...")
+
+	// Stop running commands first so their streams complete and the
+	// in-flight SSE requests can return before the server closes
+	cmdExecutor.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Errorf("Server shutdown error: %v", err)
+	}
 }
 
 func setupLogging(cfg *config.Config) {
@@ -3,6 +3,9 @@ module YALS
 go 1.25.5
 
 require (
-	golang.org/x/text v0.33.0
+	golang.org/x/net v0.57.0
+	golang.org/x/text v0.40.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.47.0
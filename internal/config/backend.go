@@ -0,0 +1,59 @@
+package config
+
+import (
+	"YALS/internal/probe"
+	"fmt"
+	"maps"
+)
+
+// validateBackend checks a command run by a built-in backend, with its
+// options and the defaults of its parameters
+func validateBackend(name string, cmd *CommandTemplate) error {
+	backend, exists := probe.Lookup(cmd.Type)
+	if !exists {
+		return fmt.Errorf("command %s: unknown type %q", name, cmd.Type)
+	}
+	if !cmd.Template.IsEmpty() || !cmd.TemplateIPv4.IsEmpty() || !cmd.TemplateIPv6.IsEmpty() || cmd.Shell {
+		return fmt.Errorf("command %s: commands of type %s take no template", name, cmd.Type)
+	}
+	if cmd.PTY {
+		return fmt.Errorf("command %s: commands of type %s do not run in a pseudo-terminal", name, cmd.Type)
+	}
+	if cmd.ANSI != "" {
+		return fmt.Errorf("command %s: commands of type %s print no escape sequences", name, cmd.Type)
+	}
+	if cmd.Parser != "" {
+		return fmt.Errorf("command %s: commands of type %s send structured events of their own", name, cmd.Type)
+	}
+
+	if err := backend.Check(cmd.BackendOptions(nil)); err != nil {
+		return fmt.Errorf("command %s: %w", name, err)
+	}
+	return nil
+}
+
+// BackendOptions returns the options a built-in backend runs with: the
+// configured options, overridden by the rendered parameter values. Parameters
+// missing from params use their default.
+func (c CommandTemplate) BackendOptions(params map[string]string) probe.Options {
+	opts := probe.Options{}
+	maps.Copy(opts, c.Options)
+	for _, param := range c.Params {
+		if value, exists := params[param.Name]; exists {
+			opts[param.Name] = value
+		} else {
+			opts[param.Name] = param.Placeholder(param.Default)
+		}
+	}
+	return opts
+}
+
+// TargetKind returns the kind of target the command takes. Commands run
+// from templates take hosts.
+func (c CommandTemplate) TargetKind() probe.TargetKind {
+	backend, exists := probe.Lookup(c.Type)
+	if !exists {
+		return probe.TargetHost
+	}
+	return backend.Target
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"YALS/internal/parser"
 	"fmt"
 	"os"
 	"slices"
@@ -32,19 +33,76 @@ type Config struct {
 		Description string `yaml:"description"`
 	} `yaml:"info"`
 
+	Execution struct {
+		Timeout         int `yaml:"timeout"`
+		MaxOutputBytes  int `yaml:"max_output_bytes"`
+		MaxLines        int `yaml:"max_lines"`
+		KillGracePeriod int `yaml:"kill_grace_period"`
+		MaxLineLength   int `yaml:"max_line_length"` // Bytes, longer lines are split
+		ReplayBuffer    int `yaml:"replay_buffer"`   // Outputs kept per execution for clients catching up
+		ReattachWindow  int `yaml:"reattach_window"` // Seconds a command outlives a dropped connection, negative disables
+	} `yaml:"execution"`
+
+	Concurrency struct {
+		MaxGlobal    int `yaml:"max_global"`
+		MaxPerClient int `yaml:"max_per_client"`
+		QueueTimeout int `yaml:"queue_timeout"`
+	} `yaml:"concurrency"`
+
 	Commands map[string]CommandTemplate `yaml:"commands"`
 }
 
 type CommandTemplate struct {
-	Template     string `yaml:"template"`
-	IgnoreTarget bool   `yaml:"ignore_target"`
+	Type           string            `yaml:"type"`    // Built-in backend, empty for external commands
+	Options        map[string]string `yaml:"options"` // Settings of the built-in backend
+	Template       CommandLine       `yaml:"template"`
+	TemplateIPv4   CommandLine       `yaml:"template_ipv4"` // Used instead of template for IPv4 targets
+	TemplateIPv6   CommandLine       `yaml:"template_ipv6"` // Used instead of template for IPv6 targets
+	IPVersions     []string          `yaml:"ip_versions"`   // IP versions offered to users, all by default
+	Shell          bool              `yaml:"shell"`         // Run the template through /bin/bash -c
+	IgnoreTarget   bool              `yaml:"ignore_target"`
+	Timeout        int               `yaml:"timeout"`          // Seconds, 0 uses execution.timeout
+	MaxOutputBytes int               `yaml:"max_output_bytes"` // 0 uses execution.max_output_bytes
+	MaxLines       int               `yaml:"max_lines"`        // 0 uses execution.max_lines
+	MaxLineLength  int               `yaml:"max_line_length"`  // 0 uses execution.max_line_length
+	StreamMode     string            `yaml:"stream_mode"`      // "line" (default) or "raw"
+	PTY            bool              `yaml:"pty"`              // Run attached to a pseudo-terminal
+	ANSI           string            `yaml:"ansi"`             // Handling of escape sequences, see ANSIStrip
+	Parser         string            `yaml:"parser"`           // Parser of the output for the result event, see parser.Lookup
+	MaxConcurrent  int               `yaml:"max_concurrent"`   // 0 means no per-command limit
+	ShareWindow    int               `yaml:"share_window"`     // Seconds identical requests join a running execution
+	Params         []CommandParam    `yaml:"params"`
 }
 
 type CommandName struct {
-	Name         string `json:"name"`
-	IgnoreTarget bool   `json:"ignore_target"`
+	Name         string         `json:"name"`
+	IgnoreTarget bool           `json:"ignore_target"`
+	TargetType   string         `json:"target_type"` // Kind of target, see probe.TargetKind
+	IPVersions   []string       `json:"ip_versions"`
+	Params       []CommandParam `json:"params,omitempty"`
 }
 
+// Stream modes. Line mode sends output line by line, raw mode sends chunks
+// as they are read, carriage returns included.
+const (
+	StreamLine = "line"
+	StreamRaw  = "raw"
+)
+
+// Handling of the escape sequences in the output of commands. Strip mode
+// removes them, passthrough mode forwards them as they are and spans mode
+// turns colors and text attributes into styled spans, removing the rest.
+// Commands run in a pseudo-terminal strip them by default, others pass
+// them through.
+const (
+	ANSIStrip       = "strip"
+	ANSIPassthrough = "passthrough"
+	ANSISpans       = "spans"
+)
+
+// IP versions a command can be run with
+var ipVersions = []string{"auto", "ipv4", "ipv6"}
+
 type commandWithLine struct {
 	Name    string
 	Line    int
@@ -90,6 +148,103 @@ func LoadConfig(filename string) (*Config, error) {
 	if config.Info.Description == "" {
 		config.Info.Description = "N/A"
 	}
+	if config.Execution.Timeout == 0 {
+		config.Execution.Timeout = 120
+	}
+	if config.Execution.MaxOutputBytes == 0 {
+		config.Execution.MaxOutputBytes = 1 << 20
+	}
+	if config.Execution.MaxLines == 0 {
+		config.Execution.MaxLines = 10000
+	}
+	if config.Execution.KillGracePeriod == 0 {
+		config.Execution.KillGracePeriod = 3
+	}
+	if config.Execution.MaxLineLength == 0 {
+		config.Execution.MaxLineLength = 64 << 10
+	}
+	if config.Execution.ReplayBuffer <= 0 {
+		config.Execution.ReplayBuffer = 1000
+	}
+	if config.Execution.ReattachWindow == 0 {
+		config.Execution.ReattachWindow = 30
+	}
+	if config.Concurrency.MaxGlobal == 0 {
+		config.Concurrency.MaxGlobal = 10
+	}
+	if config.Concurrency.MaxPerClient == 0 {
+		config.Concurrency.MaxPerClient = 2
+	}
+	if config.Concurrency.QueueTimeout == 0 {
+		config.Concurrency.QueueTimeout = 60
+	}
+
+	for name, cmd := range config.Commands {
+		if err := validateParams(name, &cmd); err != nil {
+			return nil, err
+		}
+		if cmd.Type != "" {
+			if err := validateBackend(name, &cmd); err != nil {
+				return nil, err
+			}
+		} else if err := validateTemplate(name, &cmd); err != nil {
+			return nil, err
+		}
+
+		if len(cmd.IPVersions) == 0 {
+			cmd.IPVersions = ipVersions
+		}
+		for _, version := range cmd.IPVersions {
+			if !slices.Contains(ipVersions, version) {
+				return nil, fmt.Errorf("command %s: unknown IP version %q", name, version)
+			}
+		}
+
+		switch cmd.StreamMode {
+		case "":
+			cmd.StreamMode = StreamLine
+		case StreamLine, StreamRaw:
+		default:
+			return nil, fmt.Errorf("command %s: unknown stream mode %q", name, cmd.StreamMode)
+		}
+
+		switch cmd.ANSI {
+		case "":
+			cmd.ANSI = ANSIPassthrough
+			if cmd.PTY {
+				cmd.ANSI = ANSIStrip
+			}
+		case ANSIStrip, ANSIPassthrough:
+		case ANSISpans:
+			// Spans do not survive the carriage returns of raw output
+			if cmd.StreamMode == StreamRaw {
+				return nil, fmt.Errorf("command %s: ansi mode %s needs the line stream mode", name, cmd.ANSI)
+			}
+		default:
+			return nil, fmt.Errorf("command %s: unknown ansi mode %q", name, cmd.ANSI)
+		}
+
+		if cmd.Parser != "" {
+			if _, exists := parser.Lookup(cmd.Parser); !exists {
+				return nil, fmt.Errorf("command %s: unknown parser %q", name, cmd.Parser)
+			}
+		}
+
+		// Commands without their own limits inherit the execution defaults
+		if cmd.Timeout == 0 {
+			cmd.Timeout = config.Execution.Timeout
+		}
+		if cmd.MaxOutputBytes == 0 {
+			cmd.MaxOutputBytes = config.Execution.MaxOutputBytes
+		}
+		if cmd.MaxLines == 0 {
+			cmd.MaxLines = config.Execution.MaxLines
+		}
+		if cmd.MaxLineLength == 0 {
+			cmd.MaxLineLength = config.Execution.MaxLineLength
+		}
+		config.Commands[name] = cmd
+	}
 
 	globalConfig = &config
 
@@ -140,16 +295,8 @@ func extractCommandsFromNode(node *yaml.Node, commands *[]commandWithLine) {
 						var cmdTemplate CommandTemplate
 
 						// Parse command properties
-						for k := 0; k < len(cmdValueNode.Content); k += 2 {
-							propKey := cmdValueNode.Content[k].Value
-							propValue := cmdValueNode.Content[k+1]
-
-							switch propKey {
-							case "template":
-								cmdTemplate.Template = propValue.Value
-							case "ignore_target":
-								cmdTemplate.IgnoreTarget = propValue.Value == "true"
-							}
+						if err := cmdValueNode.Decode(&cmdTemplate); err != nil {
+							continue
 						}
 
 						*commands = append(*commands, commandWithLine{
@@ -165,6 +312,24 @@ func extractCommandsFromNode(node *yaml.Node, commands *[]commandWithLine) {
 	}
 }
 
+// TemplateFor returns the template variant for an IP version ("ipv4" or
+// "ipv6"), falling back to the generic template
+func (c CommandTemplate) TemplateFor(ipVersion string) CommandLine {
+	switch {
+	case ipVersion == "ipv4" && !c.TemplateIPv4.IsEmpty():
+		return c.TemplateIPv4
+	case ipVersion == "ipv6" && !c.TemplateIPv6.IsEmpty():
+		return c.TemplateIPv6
+	default:
+		return c.Template
+	}
+}
+
+// SupportsIPVersion reports whether users may run the command with an IP version
+func (c CommandTemplate) SupportsIPVersion(ipVersion string) bool {
+	return slices.Contains(c.IPVersions, ipVersion)
+}
+
 func GetConfig() *Config {
 	return globalConfig
 }
@@ -193,6 +358,9 @@ func (s *ServerInfo) GetCommands() []CommandName {
 			commands = append(commands, CommandName{
 				Name:         name,
 				IgnoreTarget: template.IgnoreTarget,
+				TargetType:   string(template.TargetKind()),
+				IPVersions:   template.IPVersions,
+				Params:       template.Params,
 			})
 		}
 	}
@@ -210,6 +378,9 @@ func (s *ServerInfo) GetCommands() []CommandName {
 		commands = append(commands, CommandName{
 			Name:         name,
 			IgnoreTarget: template.IgnoreTarget,
+			TargetType:   string(template.TargetKind()),
+			IPVersions:   template.IPVersions,
+			Params:       template.Params,
 		})
 	}
 
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadCommands loads a configuration made of the given commands section
+func loadCommands(t *testing.T, commands string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("commands:\n"+commands), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestShellOperators(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		operator string // Reported operator, empty when the config loads
+	}{
+		{"pipe", `
+  c:
+    template: "mtr -r {{target}} | tail -n 5"
+`, "|"},
+		{"and", `
+  c:
+    template: "ping -c 1 {{target}} && echo up"
+`, "&&"},
+		{"semicolon", `
+  c:
+    template: "ping -c 1 {{target}}; echo done"
+`, ";"},
+		{"redirection", `
+  c:
+    template: "traceroute {{target}} 2>/dev/null"
+`, ">"},
+		{"substitution", `
+  c:
+    template: "ping -c $(nproc) {{target}}"
+`, "$("},
+		{"substitution in double quotes", `
+  c:
+    template: 'ping -c "$(nproc)" {{target}}'
+`, "$("},
+		{"backtick", "\n  c:\n    template: \"ping -c `nproc` {{target}}\"\n", "`"},
+		{"operator argument in list", `
+  c:
+    template: ["mtr", "-r", "{{target}}", "|", "tail"]
+`, "|"},
+		{"ipv6 variant", `
+  c:
+    template_ipv4: "ping -4 {{target}}"
+    template_ipv6: "ping -6 {{target}} > /tmp/out"
+`, ">"},
+		{"shell", `
+  c:
+    template: "mtr -r {{target}} | tail -n 5"
+    shell: true
+`, ""},
+		{"single quotes", `
+  c:
+    template: "grep 'a|b;c>d' {{target}}"
+    ignore_target: true
+`, ""},
+		{"double quotes", `
+  c:
+    template: 'echo "a|b" {{target}}'
+`, ""},
+		{"script argument in list", `
+  c:
+    template: ["bash", "-c", "echo start; sleep 1 | cat", "{{target}}"]
+`, ""},
+		{"plain", `
+  c:
+    template: "ping -c 4 {{ip_version_flag}} {{target}}"
+`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadCommands(t, test.commands)
+			switch {
+			case test.operator == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.operator != "" && err == nil:
+				t.Fatalf("expected the operator %q to be rejected", test.operator)
+			case test.operator != "" && !strings.Contains(err.Error(), `shell operator "`+test.operator+`"`):
+				t.Fatalf("expected the operator %q to be reported, got: %v", test.operator, err)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	if _, err := LoadConfig("../../config.yaml"); err != nil {
+		t.Fatalf("example config does not load: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// Parameter types
+const (
+	ParamInt  = "int"
+	ParamEnum = "enum"
+	ParamBool = "bool"
+)
+
+var paramNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// CommandParam declares a user tunable parameter of a command. Its value is
+// substituted into the template through the {{name}} placeholder.
+type CommandParam struct {
+	Name    string   `yaml:"name" json:"name"`
+	Label   string   `yaml:"label" json:"label,omitempty"`
+	Type    string   `yaml:"type" json:"type"`
+	Min     *int     `yaml:"min" json:"min,omitempty"`       // int only
+	Max     *int     `yaml:"max" json:"max,omitempty"`       // int only
+	Values  []string `yaml:"values" json:"values,omitempty"` // enum only
+	Flag    string   `yaml:"flag" json:"-"`                  // bool only, rendered when true
+	Default any      `yaml:"default" json:"default"`
+}
+
+// Placeholder renders a validated parameter value for the command template.
+// A bool with a flag renders to the flag or to nothing.
+func (p CommandParam) Placeholder(value any) string {
+	switch v := value.(type) {
+	case bool:
+		if p.Flag != "" {
+			if v {
+				return p.Flag
+			}
+			return ""
+		}
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// validateParams checks the parameter declarations of a command and fills
+// in missing defaults
+func validateParams(name string, cmd *CommandTemplate) error {
+	seen := []string{}
+
+	for i := range cmd.Params {
+		param := &cmd.Params[i]
+
+		if !paramNamePattern.MatchString(param.Name) {
+			return fmt.Errorf("command %s: invalid parameter name %q", name, param.Name)
+		}
+		if slices.Contains(templatePlaceholders, param.Name) {
+			return fmt.Errorf("command %s: parameter %s shadows a built-in placeholder", name, param.Name)
+		}
+		if slices.Contains(seen, param.Name) {
+			return fmt.Errorf("command %s: duplicate parameter %s", name, param.Name)
+		}
+		seen = append(seen, param.Name)
+
+		if param.Label == "" {
+			param.Label = param.Name
+		}
+
+		switch param.Type {
+		case ParamInt:
+			if param.Min == nil || param.Max == nil || *param.Min > *param.Max {
+				return fmt.Errorf("command %s: parameter %s needs min <= max", name, param.Name)
+			}
+			if param.Default == nil {
+				param.Default = *param.Min
+			}
+			def, ok := param.Default.(int)
+			if !ok || def < *param.Min || def > *param.Max {
+				return fmt.Errorf("command %s: parameter %s has an invalid default", name, param.Name)
+			}
+
+		case ParamEnum:
+			if len(param.Values) == 0 {
+				return fmt.Errorf("command %s: parameter %s needs values", name, param.Name)
+			}
+			if param.Default == nil {
+				param.Default = param.Values[0]
+			}
+			def, ok := param.Default.(string)
+			if !ok || !slices.Contains(param.Values, def) {
+				return fmt.Errorf("command %s: parameter %s has an invalid default", name, param.Name)
+			}
+
+		case ParamBool:
+			if param.Default == nil {
+				param.Default = false
+			}
+			if _, ok := param.Default.(bool); !ok {
+				return fmt.Errorf("command %s: parameter %s has an invalid default", name, param.Name)
+			}
+
+		default:
+			return fmt.Errorf("command %s: parameter %s has unknown type %q", name, param.Name, param.Type)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Placeholders filled in by the executor for every command
+var templatePlaceholders = []string{"target", "host", "port", "ip", "ip_version_flag"}
+
+// Placeholders that carry the target into the command
+var targetPlaceholders = []string{"target", "host", "port", "ip"}
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// Shell operators that templates not run through the shell would pass to
+// the command as literal arguments, longest first
+var shellOperators = []string{"&&", "||", ">>", "$(", "|", ";", ">", "<", "`"}
+
+// CommandLine is a command template. In YAML it is written either as a list
+// of arguments or as a single string, which is split into arguments on
+// whitespace with single and double quotes grouping words.
+type CommandLine struct {
+	Line string
+	Args []string
+	list bool // Written as a list, whose arguments are used as they are
+}
+
+// UnmarshalYAML accepts both the string and the list form of a template
+func (c *CommandLine) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		args, err := SplitArgs(value.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", value.Line, err)
+		}
+		c.Line = value.Value
+		c.Args = args
+	case yaml.SequenceNode:
+		var args []string
+		if err := value.Decode(&args); err != nil {
+			return err
+		}
+		c.Line = strings.Join(args, " ")
+		c.Args = args
+		c.list = true
+	default:
+		return fmt.Errorf("line %d: template must be a string or a list", value.Line)
+	}
+	return nil
+}
+
+// IsEmpty reports whether the template has no arguments
+func (c CommandLine) IsEmpty() bool {
+	return len(c.Args) == 0
+}
+
+// Placeholders returns the names of the placeholders used by the template
+func (c CommandLine) Placeholders() []string {
+	var names []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(c.Line, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// shellOperator returns the first shell operator in the template, or an
+// empty string if it has none. In the string form, operators in single
+// quotes are ignored, and only substitutions count in double quotes. In the
+// list form, only arguments made up of an operator count, as quoted
+// arguments like the script of bash -c are expected to contain them.
+func (c CommandLine) shellOperator() string {
+	if c.list {
+		for _, arg := range c.Args {
+			if slices.Contains(shellOperators, arg) {
+				return arg
+			}
+		}
+		return ""
+	}
+
+	var quote rune
+	for i, r := range c.Line {
+		switch {
+		case quote == '\'':
+			if r == quote {
+				quote = 0
+			}
+			continue
+		case quote == '"':
+			switch {
+			case r == quote:
+				quote = 0
+			case r == '`':
+				return "`"
+			case strings.HasPrefix(c.Line[i:], "$("):
+				return "$("
+			}
+			continue
+		case r == '"' || r == '\'':
+			quote = r
+			continue
+		}
+		for _, op := range shellOperators {
+			if strings.HasPrefix(c.Line[i:], op) {
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+// RenderArgs substitutes placeholders in every argument. An argument made up
+// only of placeholders that rendered empty is dropped, so an optional flag
+// such as {{ip_version_flag}} does not leave an empty argument behind.
+func (c CommandLine) RenderArgs(vars map[string]string) ([]string, error) {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		rendered, err := renderPlaceholders(arg, vars, nil)
+		if err != nil {
+			return nil, err
+		}
+		if rendered == "" && arg != "" {
+			continue
+		}
+		args = append(args, rendered)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("template renders to an empty command")
+	}
+	return args, nil
+}
+
+// RenderShell substitutes placeholders in the template line with shell
+// quoted values, for templates that run through the shell
+func (c CommandLine) RenderShell(vars map[string]string) (string, error) {
+	return renderPlaceholders(c.Line, vars, shellQuote)
+}
+
+func renderPlaceholders(s string, vars map[string]string, quote func(string) string) (string, error) {
+	var missing string
+	rendered := placeholderPattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		value, exists := vars[name]
+		if !exists {
+			missing = name
+			return ""
+		}
+		if quote != nil && value != "" {
+			return quote(value)
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("unknown placeholder {{%s}}", missing)
+	}
+	return rendered, nil
+}
+
+// shellQuote wraps s in single quotes for /bin/bash
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// SplitArgs splits a command line into arguments on whitespace. Single and
+// double quotes group words into one argument and are removed.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// validateTemplate checks the templates of a command. A command needs a
+// generic template unless it has variants for both IP versions.
+func validateTemplate(name string, cmd *CommandTemplate) error {
+	if cmd.Template.IsEmpty() && (cmd.TemplateIPv4.IsEmpty() || cmd.TemplateIPv6.IsEmpty()) {
+		return fmt.Errorf("command %s: template is empty", name)
+	}
+
+	for _, line := range []*CommandLine{&cmd.Template, &cmd.TemplateIPv4, &cmd.TemplateIPv6} {
+		if line.IsEmpty() {
+			continue
+		}
+		if err := validateCommandLine(name, cmd, line); err != nil {
+			return err
+		}
+		if op := line.shellOperator(); op != "" && !cmd.Shell {
+			return fmt.Errorf("command %s: template uses the shell operator %q, set shell: true to run it through the shell", name, op)
+		}
+	}
+	return nil
+}
+
+// validateCommandLine checks that a template only uses built-in placeholders
+// and the command's parameters, and appends {{target}} to templates written
+// before placeholders existed
+func validateCommandLine(name string, cmd *CommandTemplate, line *CommandLine) error {
+	usesTarget := false
+	for _, placeholder := range line.Placeholders() {
+		isParam := slices.ContainsFunc(cmd.Params, func(p CommandParam) bool { return p.Name == placeholder })
+		if !isParam && !slices.Contains(templatePlaceholders, placeholder) {
+			return fmt.Errorf("command %s: unknown placeholder {{%s}}", name, placeholder)
+		}
+		if slices.Contains(targetPlaceholders, placeholder) {
+			usesTarget = true
+		}
+	}
+
+	if !cmd.IgnoreTarget && !usesTarget {
+		line.Line += " {{target}}"
+		line.Args = append(line.Args, "{{target}}")
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RecordTypes are the record types that can be queried, by name
+var RecordTypes = map[string]uint16{
+	"A":     1,
+	"NS":    2,
+	"CNAME": 5,
+	"SOA":   6,
+	"PTR":   12,
+	"MX":    15,
+	"TXT":   16,
+	"AAAA":  28,
+	"SRV":   33,
+	"CAA":   257,
+}
+
+// rcodeNames are the names of the response codes, as dig shows them
+var rcodeNames = map[int]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// Record is a resource record of an answer
+type Record struct {
+	Name string
+	Type string
+	TTL  uint32
+	Data string // Presentation format, as in zone files
+}
+
+// Answer is the response to a query
+type Answer struct {
+	Server   string // Upstream that answered
+	Status   string // Response code, such as NOERROR or NXDOMAIN
+	Records  []Record
+	Duration time.Duration
+}
+
+// Query looks up records of a type using the DoH servers, starting with
+// the fastest one
+func (r *DNSResolver) Query(ctx context.Context, name, recordType string) (*Answer, error) {
+	r.mutex.RLock()
+	servers := append([]*DNSServer{r.servers[r.currentIndex]}, r.servers...)
+	r.mutex.RUnlock()
+
+	var errs []error
+	for i, server := range servers {
+		if i > 0 && server == servers[0] {
+			continue
+		}
+		answer, err := queryDoHRecords(ctx, server, name, recordType)
+		if err == nil {
+			return answer, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server.Name, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// queryDoHRecords performs a DoH query returning full records
+func queryDoHRecords(ctx context.Context, server *DNSServer, name, recordType string) (*Answer, error) {
+	query := url.Values{"name": {name}, "type": {recordType}}
+	req, err := http.NewRequestWithContext(ctx, "GET", server.Address+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query DoH server: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Since(start)
+
+	var dohResp struct {
+		Status int `json:"Status"`
+		Answer []struct {
+			Name string `json:"name"`
+			Type uint16 `json:"type"`
+			TTL  uint32 `json:"TTL"`
+			Data string `json:"data"`
+		} `json:"Answer"`
+	}
+	if err := json.Unmarshal(body, &dohResp); err != nil {
+		return nil, fmt.Errorf("failed to parse DoH response: %v", err)
+	}
+
+	answer := &Answer{
+		Server:   fmt.Sprintf("%s (%s)", server.Name, server.Address),
+		Status:   rcodeName(dohResp.Status),
+		Duration: duration,
+	}
+	for _, record := range dohResp.Answer {
+		answer.Records = append(answer.Records, Record{
+			Name: record.Name,
+			Type: typeName(record.Type),
+			TTL:  record.TTL,
+			Data: record.Data,
+		})
+	}
+	return answer, nil
+}
+
+// QuerySystem looks up records of a type using the first name server of
+// /etc/resolv.conf, over UDP and then TCP if the answer is truncated
+func QuerySystem(ctx context.Context, name, recordType string) (*Answer, error) {
+	server, err := systemNameServer()
+	if err != nil {
+		return nil, err
+	}
+
+	typ, exists := RecordTypes[recordType]
+	if !exists {
+		return nil, fmt.Errorf("unsupported record type: %s", recordType)
+	}
+	fqdn, err := dnsmessage.NewName(dotted(name))
+	if err != nil {
+		return nil, fmt.Errorf("invalid name: %s", name)
+	}
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  fqdn,
+			Type:  dnsmessage.Type(typ),
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := exchange(ctx, "udp", server, packed)
+	if err == nil && resp.Truncated {
+		resp, err = exchange(ctx, "tcp", server, packed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %v", server, err)
+	}
+	if resp.ID != query.ID {
+		return nil, fmt.Errorf("mismatched response ID from %s", server)
+	}
+
+	answer := &Answer{
+		Server:   server + " (system)",
+		Status:   rcodeName(int(resp.RCode)),
+		Duration: time.Since(start),
+	}
+	for _, resource := range resp.Answers {
+		answer.Records = append(answer.Records, Record{
+			Name: resource.Header.Name.String(),
+			Type: typeName(uint16(resource.Header.Type)),
+			TTL:  resource.Header.TTL,
+			Data: resourceData(resource.Body),
+		})
+	}
+	return answer, nil
+}
+
+// exchange sends a packed query to server and reads the response
+func exchange(ctx context.Context, network, server string, packed []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		// Messages over TCP are preceded by their length
+		if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(packed)))); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		n, err = io.ReadFull(conn, buf[:length])
+	} else {
+		if _, err := conn.Write(packed); err != nil {
+			return nil, err
+		}
+		n, err = conn.Read(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return &msg, nil
+}
+
+// systemNameServer returns the address of the first name server of
+// /etc/resolv.conf
+func systemNameServer() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no system name server: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			// Zones of link-local IPv6 addresses are kept by JoinHostPort
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("no name server in /etc/resolv.conf")
+}
+
+// resourceData formats the data of a record in presentation format
+func resourceData(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.PTRResource:
+		return b.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dnsmessage.TXTResource:
+		quoted := make([]string, len(b.TXT))
+		for i, txt := range b.TXT {
+			quoted[i] = strconv.Quote(txt)
+		}
+		return strings.Join(quoted, " ")
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", b.NS, b.MBox, b.Serial, b.Refresh, b.Retry, b.Expire, b.MinTTL)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", b.Priority, b.Weight, b.Port, b.Target)
+	case *dnsmessage.UnknownResource:
+		if b.Type == dnsmessage.Type(RecordTypes["CAA"]) {
+			return caaData(b.Data)
+		}
+		return fmt.Sprintf("\\# %d %x", len(b.Data), b.Data)
+	default:
+		return body.GoString()
+	}
+}
+
+// caaData formats the flags, tag and value of a CAA record
+func caaData(data []byte) string {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return fmt.Sprintf("\\# %d %x", len(data), data)
+	}
+	tagEnd := 2 + int(data[1])
+	return fmt.Sprintf("%d %s %s", data[0], data[2:tagEnd], strconv.Quote(string(data[tagEnd:])))
+}
+
+// ReverseName returns the name PTR records of an IP address are found at
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "%x.%x.", ip[i]&0x0f, ip[i]>>4)
+	}
+	return b.String() + "ip6.arpa."
+}
+
+func typeName(typ uint16) string {
+	for name, t := range RecordTypes {
+		if t == typ {
+			return name
+		}
+	}
+	return fmt.Sprintf("TYPE%d", typ)
+}
+
+func rcodeName(rcode int) string {
+	if name, exists := rcodeNames[rcode]; exists {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// dotted returns a name with the trailing dot of fully qualified names
+func dotted(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
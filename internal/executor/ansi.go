@@ -0,0 +1,228 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Span is a piece of output text in one style, sent with the output of
+// commands in the ansi spans mode
+type Span struct {
+	Text string `json:"text"`
+	textStyle
+}
+
+// textStyle is what the SGR sequences of a terminal set. Colors are named
+// after the 16 basic terminal colors, such as "red" or "bright_red", or
+// given as "#rrggbb"; empty means the default color.
+type textStyle struct {
+	FG        string `json:"fg,omitempty"`
+	BG        string `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Dim       bool   `json:"dim,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+}
+
+// colorNames are the basic terminal colors, then their bright variants
+var colorNames = [16]string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"bright_black", "bright_red", "bright_green", "bright_yellow",
+	"bright_blue", "bright_magenta", "bright_cyan", "bright_white",
+}
+
+// styler splits the output of a stream into text and styled spans. The
+// style carries over from one output to the next, as on a terminal.
+type styler struct {
+	style   textStyle
+	pending string // Start of an SGR sequence cut off at the end of the last output
+}
+
+// styledEmit returns an emit function replacing the SGR sequences in the
+// outputs of a stream with spans
+func styledEmit(emit func(Output)) func(Output) {
+	s := &styler{}
+	return func(output Output) {
+		output.Output, output.Spans = s.apply(output.Output)
+		emit(output)
+	}
+}
+
+// apply removes the SGR sequences of text, a piece of output filtered by a
+// terminalReader keeping them, and returns the plain text and its spans.
+// Spans are nil when none of the text is styled.
+func (s *styler) apply(text string) (string, []Span) {
+	text = s.pending + text
+	s.pending = ""
+
+	var (
+		plain  strings.Builder
+		spans  []Span
+		styled bool
+	)
+	add := func(piece string) {
+		if piece == "" {
+			return
+		}
+		plain.WriteString(piece)
+		styled = styled || s.style != textStyle{}
+		if n := len(spans); n > 0 && spans[n-1].textStyle == s.style {
+			spans[n-1].Text += piece
+			return
+		}
+		spans = append(spans, Span{Text: piece, textStyle: s.style})
+	}
+
+	for text != "" {
+		start := strings.IndexByte(text, 0x1b)
+		if start < 0 {
+			add(text)
+			break
+		}
+		add(text[:start])
+		text = text[start:]
+
+		end := sequenceEnd(text)
+		if end == len(text) && end <= len("\x1b[")+maxSequenceParams {
+			s.pending = text
+			break
+		}
+		if end == len(text) || text[end] != 'm' {
+			// Not a sequence the reader keeps, the escape is dropped
+			text = text[1:]
+			continue
+		}
+		s.style.update(text[2:end])
+		text = text[end+1:]
+	}
+
+	if !styled {
+		return plain.String(), nil
+	}
+	return plain.String(), spans
+}
+
+// sequenceEnd returns the index of the final byte of the control sequence
+// text starts with, or len(text) when text ends before it. Text starting
+// with an escape that does not start a control sequence ends at index 0.
+func sequenceEnd(text string) int {
+	if len(text) < 2 {
+		return len(text)
+	}
+	if text[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(text); i++ {
+		if text[i] < 0x20 || text[i] > 0x3f {
+			return i
+		}
+	}
+	return len(text)
+}
+
+// update applies the parameters of an SGR sequence. Sequences using
+// colon-separated subparameters are ignored.
+func (st *textStyle) update(params string) {
+	if strings.Trim(params, "0123456789;") != "" {
+		return
+	}
+
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code, _ := strconv.Atoi(codes[i]) // Empty codes mean 0
+		switch {
+		case code == 0:
+			*st = textStyle{}
+		case code == 1:
+			st.Bold = true
+		case code == 2:
+			st.Dim = true
+		case code == 3:
+			st.Italic = true
+		case code == 4:
+			st.Underline = true
+		case code == 22:
+			st.Bold, st.Dim = false, false
+		case code == 23:
+			st.Italic = false
+		case code == 24:
+			st.Underline = false
+		case code >= 30 && code <= 37:
+			st.FG = colorNames[code-30]
+		case code == 38:
+			color, used := extendedColor(codes[i+1:])
+			st.FG = color
+			i += used
+		case code == 39:
+			st.FG = ""
+		case code >= 40 && code <= 47:
+			st.BG = colorNames[code-40]
+		case code == 48:
+			color, used := extendedColor(codes[i+1:])
+			st.BG = color
+			i += used
+		case code == 49:
+			st.BG = ""
+		case code >= 90 && code <= 97:
+			st.FG = colorNames[code-90+8]
+		case code >= 100 && code <= 107:
+			st.BG = colorNames[code-100+8]
+		}
+	}
+}
+
+// extendedColor reads the color of a 38 or 48 SGR code from the codes
+// following it, either 5;n for the 256 color palette or 2;r;g;b, and
+// returns it with the number of codes used. Malformed colors use up the
+// remaining codes and reset the color to the default.
+func extendedColor(codes []string) (string, int) {
+	used := len(codes)
+	if len(codes) > 0 && codes[0] == "5" {
+		used = 2
+	} else if len(codes) > 0 && codes[0] == "2" {
+		used = 4
+	}
+	if used > len(codes) {
+		return "", len(codes)
+	}
+
+	values := make([]int, used)
+	for i, code := range codes[:used] {
+		value, err := strconv.Atoi(code)
+		if err != nil || value > 255 {
+			return "", len(codes)
+		}
+		values[i] = value
+	}
+
+	switch used {
+	case 2:
+		return paletteColor(values[1]), used
+	case 4:
+		return fmt.Sprintf("#%02x%02x%02x", values[1], values[2], values[3]), used
+	default:
+		return "", used
+	}
+}
+
+// paletteColor returns a color of the 256 color palette of xterm: the basic
+// colors, a 6x6x6 color cube and a gray ramp
+func paletteColor(n int) string {
+	if n < 16 {
+		return colorNames[n]
+	}
+	if n >= 232 {
+		gray := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+
+	level := func(i int) int {
+		if i == 0 {
+			return 0
+		}
+		return 55 + i*40
+	}
+	n -= 16
+	return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"YALS/internal/probe"
+	"YALS/internal/validator"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// backendOutput passes the output of a built-in backend on as outputs,
+// within the command's output limits
+type backendOutput struct {
+	emit    func(Output)
+	limiter *outputLimiter
+}
+
+func (o *backendOutput) Printf(format string, args ...any) {
+	line := fmt.Sprintf(format, args...)
+	if !o.limiter.allow(len(line)+1, 1) {
+		return
+	}
+	o.emit(Output{Output: line})
+}
+
+// Event sends a structured event, counting the size of its JSON encoding
+// against the output byte limit
+func (o *backendOutput) Event(name string, data map[string]any) {
+	payload, _ := json.Marshal(data)
+	if !o.limiter.allow(len(name)+len(payload), 0) {
+		return
+	}
+	o.emit(Output{Event: name, Data: data})
+}
+
+// backendCommand prepares a command run by a built-in backend and returns
+// it with a description of the run, which identifies identical runs
+func (e *Executor) backendCommand(typ string, backend probe.Backend, opts probe.Options, vars map[string]string, ipVersion string) (runFunc, string) {
+	target := probe.Target{
+		Host: vars["host"],
+		IP:   net.ParseIP(vars["ip"]),
+		URL:  vars["url"],
+		Resolve: func(ctx context.Context, host string) (net.IP, error) {
+			return resolveTarget(host, ipVersion)
+		},
+	}
+	if vars["port"] != "" {
+		target.Port, _ = strconv.Atoi(vars["port"])
+	}
+
+	description := []string{typ}
+	for _, name := range slices.Sorted(maps.Keys(opts)) {
+		description = append(description, name+"="+opts[name])
+	}
+	if vars["url"] != "" {
+		description = append(description, vars["url"], vars["ip"])
+	} else if vars["target"] != "" {
+		description = append(description, vars["target"])
+	}
+
+	run := func(ctx context.Context, limiter *outputLimiter, emit func(Output)) {
+		err := backend.Run(ctx, target, opts, &backendOutput{emit: emit, limiter: limiter})
+		switch {
+		case ctx.Err() != nil:
+			emit(stoppedOutput(context.Cause(ctx)))
+		case err != nil:
+			emit(Output{
+				Error:      err.Error(),
+				IsComplete: true,
+				IsError:    true,
+			})
+		default:
+			emit(Output{
+				IsComplete: true,
+			})
+		}
+	}
+
+	return run, strings.Join(description, " ")
+}
+
+// resolveTarget resolves a host a backend is led to, which must be valid and
+// of the selected IP version like the targets users enter
+func resolveTarget(host, ipVersion string) (net.IP, error) {
+	inputType := validator.ValidateInput(host)
+	if inputType != validator.IPAddress && inputType != validator.Domain {
+		return nil, fmt.Errorf("invalid host: %s", host)
+	}
+
+	ip, err := resolveHost(host, ipVersion)
+	if err != nil {
+		return nil, err
+	}
+	parsed := net.ParseIP(ip)
+	if (ipVersion == "ipv4" && parsed.To4() == nil) || (ipVersion == "ipv6" && parsed.To4() != nil) {
+		return nil, fmt.Errorf("%s is not an address of the selected IP version", host)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,30 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBackendEventsLimited(t *testing.T) {
+	truncated := false
+	var outputs []Output
+	out := &backendOutput{
+		emit: func(output Output) { outputs = append(outputs, output) },
+		limiter: &outputLimiter{
+			maxBytes: 200,
+			truncate: func() { truncated = true },
+		},
+	}
+
+	out.Event("small", map[string]any{"value": 1})
+	out.Event("large", map[string]any{"value": strings.Repeat("x", 200)})
+	out.Printf("after")
+	out.Event("small", map[string]any{"value": 2})
+
+	if !truncated {
+		t.Error("an event over the limit did not truncate the command")
+	}
+	if len(outputs) != 1 || outputs[0].Event != "small" {
+		t.Errorf("got %d outputs, want only the first event", len(outputs))
+	}
+}
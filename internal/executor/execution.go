@@ -0,0 +1,189 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// execution is a single run of a command. Its latest outputs are kept in a
+// ring buffer so that subscribers can catch up on what they missed, whether
+// they attach to a shared execution after it started or reconnect after
+// their connection dropped.
+type execution struct {
+	key         string // Identifies identical executions, empty when not shared
+	createdAt   time.Time
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	buffer      []Output // Latest outputs, indexed by Seq modulo its length
+	last        int64    // Seq of the latest output
+	subscribers int
+	done        bool
+	mu          sync.Mutex
+	cond        *sync.Cond
+}
+
+// subscription is a client following an execution under its own command
+// ID. It outlives the connection streaming it, so the client can reattach.
+type subscription struct {
+	id          string
+	sessionID   string
+	fullCommand string
+	ex          *execution
+
+	// Guarded by ex.mu
+	left    bool  // No longer counted as a subscriber
+	stopped bool  // Left while others remain, its stream ends early
+	cause   error // Why it was stopped
+
+	// Guarded by Executor.commandsLock
+	cancelStream context.CancelFunc // Cancels the connection currently streaming
+	streams      int                // Number of connections so far
+	detachTimer  *time.Timer
+}
+
+func newExecution(parent context.Context, key string, bufferSize int) *execution {
+	ctx, cancel := context.WithCancelCause(parent)
+	ex := &execution{
+		key:         key,
+		createdAt:   time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
+		buffer:      make([]Output, bufferSize),
+		subscribers: 1,
+	}
+	ex.cond = sync.NewCond(&ex.mu)
+	return ex
+}
+
+// publish records an output and wakes up the subscribers. It never waits
+// for them: a stream falling a full buffer behind skips ahead, with a notice
+// of the outputs it missed.
+func (ex *execution) publish(output Output) {
+	ex.mu.Lock()
+	ex.last++
+	output.Seq = ex.last
+	ex.buffer[ex.last%int64(len(ex.buffer))] = output
+	ex.mu.Unlock()
+	ex.cond.Broadcast()
+}
+
+// finish marks the execution as complete once its final output is published
+func (ex *execution) finish() {
+	ex.mu.Lock()
+	ex.done = true
+	ex.mu.Unlock()
+	ex.cond.Broadcast()
+	ex.cancel(nil)
+}
+
+// attach adds a subscriber to an execution that is still running and has
+// not been abandoned by its other subscribers
+func (ex *execution) attach() bool {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	if ex.done || ex.subscribers == 0 {
+		return false
+	}
+	ex.subscribers++
+	return true
+}
+
+// leave removes a subscriber. The last one to leave stops the execution
+// and still receives its final output.
+func (ex *execution) leave(sub *subscription, cause error) {
+	ex.mu.Lock()
+	if ex.done || sub.left {
+		ex.mu.Unlock()
+		return
+	}
+
+	sub.left = true
+	ex.subscribers--
+	if ex.subscribers > 0 {
+		sub.stopped = true
+		sub.cause = cause
+		ex.mu.Unlock()
+		ex.cond.Broadcast()
+		return
+	}
+	ex.mu.Unlock()
+
+	ex.cancel(cause)
+}
+
+// stream forwards the outputs following seq after to outputChan. It reports
+// whether the subscription completed, as opposed to ctx being cancelled
+// first.
+func (ex *execution) stream(ctx context.Context, sub *subscription, after int64, outputChan chan<- Output) bool {
+	stopWaking := context.AfterFunc(ctx, func() {
+		// Taking the lock ensures the stream is either waiting or will see ctx
+		ex.mu.Lock()
+		ex.mu.Unlock()
+		ex.cond.Broadcast()
+	})
+	defer stopWaking()
+
+	cursor := after
+	for {
+		ex.mu.Lock()
+		for cursor >= ex.last && !ex.done && !sub.stopped && ctx.Err() == nil {
+			ex.cond.Wait()
+		}
+		if sub.stopped {
+			ex.mu.Unlock()
+			return send(ctx, outputChan, stoppedOutput(sub.cause))
+		}
+		if cursor >= ex.last && ex.done {
+			ex.mu.Unlock()
+			return true
+		}
+		if ctx.Err() != nil {
+			ex.mu.Unlock()
+			return false
+		}
+		pending := ex.since(cursor)
+		cursor = ex.last
+		ex.mu.Unlock()
+
+		for _, output := range pending {
+			if !send(ctx, outputChan, output) {
+				return false
+			}
+			if output.IsComplete {
+				return true
+			}
+		}
+	}
+}
+
+// since returns the buffered outputs following seq cursor, preceded by a
+// notice when some of them were already overwritten. Called with ex.mu held.
+func (ex *execution) since(cursor int64) []Output {
+	pending := []Output{}
+
+	oldest := max(ex.last-int64(len(ex.buffer))+1, 1)
+	if cursor < oldest-1 {
+		pending = append(pending, Output{
+			Output: fmt.Sprintf("*** %d earlier lines are no longer available ***", oldest-1-cursor),
+		})
+		cursor = oldest - 1
+	}
+
+	for seq := cursor + 1; seq <= ex.last; seq++ {
+		pending = append(pending, ex.buffer[seq%int64(len(ex.buffer))])
+	}
+	return pending
+}
+
+// send delivers an output unless ctx is cancelled first
+func send(ctx context.Context, outputChan chan<- Output, output Output) bool {
+	select {
+	case outputChan <- output:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,89 @@
+package executor
+
+import (
+	"YALS/internal/config"
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStreamSkipsAhead(t *testing.T) {
+	ex := newExecution(context.Background(), "", 4)
+	for i := 0; i < 10; i++ {
+		ex.publish(Output{Output: "line"})
+	}
+	ex.finish()
+
+	outputChan := make(chan Output, 10)
+	if !ex.stream(context.Background(), &subscription{ex: ex}, 0, outputChan) {
+		t.Fatal("stream did not complete")
+	}
+	close(outputChan)
+
+	var outputs []Output
+	for output := range outputChan {
+		outputs = append(outputs, output)
+	}
+	if len(outputs) != 5 {
+		t.Fatalf("got %d outputs, want a notice and the 4 buffered ones", len(outputs))
+	}
+	if want := "*** 6 earlier lines are no longer available ***"; outputs[0].Output != want {
+		t.Errorf("got notice %q, want %q", outputs[0].Output, want)
+	}
+	for i, output := range outputs[1:] {
+		if output.Seq != int64(7+i) {
+			t.Errorf("output %d has seq %d, want %d", i+1, output.Seq, 7+i)
+		}
+	}
+}
+
+// A client that stops reading must neither stall the command nor keep its
+// execution slot once the command times out
+func TestStalledReaderReleasesSlot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs yes and echo")
+	}
+
+	cfg := &config.Config{}
+	cfg.Execution.KillGracePeriod = 1
+	cfg.Execution.ReplayBuffer = 16
+	cfg.Concurrency.MaxGlobal = 1
+	cfg.Concurrency.MaxPerClient = 1
+	cfg.Concurrency.QueueTimeout = 10
+	cfg.Commands = map[string]config.CommandTemplate{
+		"flood": {
+			Template:     config.CommandLine{Args: []string{"yes"}},
+			IgnoreTarget: true,
+			Timeout:      1,
+		},
+		"echo": {
+			Template:     config.CommandLine{Args: []string{"echo", "ok"}},
+			IgnoreTarget: true,
+		},
+	}
+	e := NewExecutor(cfg)
+	t.Cleanup(e.Shutdown)
+
+	// Never read
+	e.Execute(context.Background(), "flood", "", "s1", make(chan Output))
+
+	outputChan := make(chan Output, 16)
+	e.Execute(context.Background(), "echo", "", "s2", outputChan)
+
+	deadline := time.After(8 * time.Second)
+	for {
+		select {
+		case output := <-outputChan:
+			if !output.IsComplete {
+				continue
+			}
+			if output.IsError {
+				t.Fatalf("echo failed: %s", output.Error)
+			}
+			return
+		case <-deadline:
+			t.Fatal("echo did not get the execution slot")
+		}
+	}
+}
@@ -2,20 +2,43 @@ package executor
 
 import (
 	"YALS/internal/config"
+	"YALS/internal/logger"
+	"YALS/internal/probe"
 	"YALS/internal/validator"
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
+	"os"
 	"os/exec"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/transform"
 )
 
-var shellOperators = []string{"|", "&&", "||", ">", "<", ";"}
+// Reasons reported with the final output when a command did not exit on its own
+const (
+	ReasonStopped      = "stopped"
+	ReasonTimeout      = "timeout"
+	ReasonTruncated    = "truncated"
+	ReasonQueueTimeout = "queue_timeout"
+)
+
+var (
+	errTimeout   = errors.New("command timed out")
+	errTruncated = errors.New("command output truncated")
+)
 
 type Output struct {
 	Output     string
@@ -23,35 +46,97 @@ type Output struct {
 	IsError    bool
 	IsComplete bool
 	IsStopped  bool
+	Reason     string
+	Event      string         // Set for structured events such as "queued"
+	Data       map[string]any // Fields of a structured event
+	Raw        bool           // Output is a chunk of raw output rather than a line
+	Spans      []Span         // Styled pieces of Output in the ansi spans mode, nil when unstyled
+	Seq        int64          // Position in the execution's output, zero for notices
+	Result     *Result        // How the process ended, set on the final output of commands that ran
+}
+
+// Result describes how a command's process ended and what it consumed
+type Result struct {
+	ExitCode   int    // -1 when the process was killed by a signal
+	Signal     string // Signal that killed the process, if any
+	StartedAt  time.Time
+	FinishedAt time.Time
+	UserTime   time.Duration
+	SystemTime time.Duration
+	MaxRSS     int64 // Peak resident set size in bytes, zero when unknown
+}
+
+// Duration returns the wall-clock time the process ran for
+func (r *Result) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
 }
 
 type Executor struct {
 	config         *config.Config
-	activeCommands map[string]*ActiveCommand
+	ctx            context.Context
+	cancel         context.CancelFunc
+	activeCommands map[string]*subscription
 	commandsLock   sync.RWMutex
-	stopSignals    map[string]chan bool
-	stopLock       sync.RWMutex
+	shared         map[string]*execution // Executions open to identical requests
+	sharedLock     sync.Mutex
+	scheduler      *scheduler
+	running        sync.WaitGroup // Executions not finished yet
 }
 
-type ActiveCommand struct {
-	Cmd         *exec.Cmd
-	FullCommand string
+// outputLimiter enforces the output byte and line caps of a command across
+// its stdout and stderr streams
+type outputLimiter struct {
+	maxBytes      int
+	maxLines      int
+	maxLineLength int // Lines are split rather than truncated at this length
+	bytes         int
+	lines         int
+	exceeded      bool
+	truncate      func()
+	mu            sync.Mutex
 }
 
+// runFunc runs a command, emitting its output until it completes or ctx is
+// cancelled
+type runFunc func(ctx context.Context, limiter *outputLimiter, emit func(Output))
+
+// rawChunkSize is the most output read at once in raw stream mode
+const rawChunkSize = 32 << 10
+
+// shutdownMargin is how long Shutdown waits for commands past their kill
+// grace period, for them to be reaped and their outputs published
+const shutdownMargin = 5 * time.Second
+
 func NewExecutor(cfg *config.Config) *Executor {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Executor{
 		config:         cfg,
-		activeCommands: make(map[string]*ActiveCommand),
-		stopSignals:    make(map[string]chan bool),
+		ctx:            ctx,
+		cancel:         cancel,
+		activeCommands: make(map[string]*subscription),
+		shared:         make(map[string]*execution),
+		scheduler: newScheduler(
+			cfg.Concurrency.MaxGlobal,
+			cfg.Concurrency.MaxPerClient,
+			time.Duration(cfg.Concurrency.QueueTimeout)*time.Second,
+		),
 	}
 }
 
-func (e *Executor) Execute(commandName, target, sessionID string, outputChan chan<- Output) string {
-	return e.ExecuteWithIPVersion(commandName, target, sessionID, "auto", outputChan)
+func (e *Executor) Execute(ctx context.Context, commandName, target, sessionID string, outputChan chan<- Output) string {
+	return e.ExecuteWithIPVersion(ctx, commandName, target, sessionID, sessionID, "auto", nil, outputChan)
 }
 
-// ExecuteWithIPVersion executes a command with IP version preference
-func (e *Executor) ExecuteWithIPVersion(commandName, target, sessionID, ipVersion string, outputChan chan<- Output) string {
+// ExecuteWithIPVersion executes a command with IP version preference.
+// params holds the rendered values of the command's parameters, as returned
+// by validator.ValidateParams; parameters missing from it use their default.
+// client identifies the requester, such as by its IP address, for the
+// per-client concurrency limit, while sessionID grants access to the command.
+// Outputs are streamed to outputChan until the command completes or ctx is
+// cancelled. A command whose ctx was cancelled keeps running for the
+// reattach window, so the client can resume it through Attach; it is
+// stopped when the window passes, or right away through Stop or Shutdown.
+func (e *Executor) ExecuteWithIPVersion(ctx context.Context, commandName, target, sessionID, client, ipVersion string, params map[string]string, outputChan chan<- Output) string {
 	cmdConfig, exists := e.config.Commands[commandName]
 	if !exists {
 		outputChan <- Output{
@@ -62,76 +147,377 @@ func (e *Executor) ExecuteWithIPVersion(commandName, target, sessionID, ipVersio
 		return ""
 	}
 
-	// Resolve domain to IP if target is a domain name
-	resolvedTarget := target
-	if target != "" && !cmdConfig.IgnoreTarget {
-		inputType := validator.ValidateInput(target)
-		if inputType == validator.Domain {
-			// Extract host without port
-			host, port := extractHostPort(target)
-
-			// Determine IP version
-			var version validator.IPVersion
-			switch ipVersion {
-			case "ipv4":
-				version = validator.IPVersionIPv4
-			case "ipv6":
-				version = validator.IPVersionIPv6
-			default:
-				version = validator.IPVersionAuto
-			}
+	// Placeholder values, left empty for commands that ignore the target
+	vars := map[string]string{
+		"target":          "",
+		"host":            "",
+		"port":            "",
+		"ip":              "",
+		"ip_version_flag": ipVersionFlag(ipVersion),
+	}
+	for _, param := range cmdConfig.Params {
+		if value, exists := params[param.Name]; exists {
+			vars[param.Name] = value
+		} else {
+			vars[param.Name] = param.Placeholder(param.Default)
+		}
+	}
 
-			// Resolve domain to IP
-			ips, err := validator.ResolveDomainWithVersion(host, version)
+	// IP version of the target, used to pick the template variant
+	family := ipVersion
+
+	target = strings.TrimSpace(target)
+	if target != "" && !cmdConfig.IgnoreTarget {
+		host, port := extractHostPort(target)
+		kind := cmdConfig.TargetKind()
+		if kind == probe.TargetQuery || kind == probe.TargetList {
+			// Prefixes, AS numbers and lists have no port
+			host, port = target, ""
+		} else if kind == probe.TargetURL {
+			u, err := url.Parse(target)
 			if err != nil {
 				outputChan <- Output{
-					Error:      fmt.Sprintf("Failed to resolve domain %s: %v", host, err),
+					Error:      "Invalid URL: " + target,
 					IsComplete: true,
 					IsError:    true,
 				}
 				return ""
 			}
+			host, port = u.Hostname(), u.Port()
+			vars["url"] = target
+		}
 
-			if len(ips) == 0 {
+		// Resolve domain to IP if target is a domain name, unless the
+		// command takes the host as entered
+		ip := host
+		if kind == probe.TargetHost {
+			var err error
+			ip, err = resolveHost(host, ipVersion)
+			if err != nil {
 				outputChan <- Output{
-					Error:      fmt.Sprintf("No IP addresses found for domain: %s", host),
+					Error:      err.Error(),
 					IsComplete: true,
 					IsError:    true,
 				}
 				return ""
 			}
+		}
+
+		if family != "ipv4" && family != "ipv6" {
+			family = "ipv6"
+			if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+				family = "ipv4"
+			}
+		}
+
+		vars["target"] = joinHostPort(ip, port)
+		vars["host"] = host
+		vars["port"] = port
+		if net.ParseIP(ip) != nil {
+			vars["ip"] = ip
+		}
+	}
+
+	var (
+		run         runFunc
+		fullCommand string
+	)
+	if cmdConfig.Type != "" {
+		backend, _ := probe.Lookup(cmdConfig.Type)
+		opts := cmdConfig.BackendOptions(params)
+		if err := backend.Check(opts); err != nil {
+			outputChan <- Output{
+				Error:      fmt.Sprintf("Invalid options for command %s: %v", commandName, err),
+				IsComplete: true,
+				IsError:    true,
+			}
+			return ""
+		}
+		run, fullCommand = e.backendCommand(cmdConfig.Type, backend, opts, vars, ipVersion)
+	} else {
+		args, err := buildCommand(cmdConfig.TemplateFor(family), cmdConfig.Shell, vars)
+		if err != nil {
+			outputChan <- Output{
+				Error:      fmt.Sprintf("Invalid template for command %s: %v", commandName, err),
+				IsComplete: true,
+				IsError:    true,
+			}
+			return ""
+		}
+		run = func(ctx context.Context, limiter *outputLimiter, emit func(Output)) {
+			e.runCommand(ctx, args, cmdConfig, limiter, emit)
+		}
+		fullCommand = strings.Join(args, " ")
+	}
+
+	sub := &subscription{
+		id:          generateCommandID(),
+		sessionID:   sessionID,
+		fullCommand: fullCommand,
+	}
+
+	sub.ex = e.attachShared(commandName, cmdConfig, fullCommand)
+	if sub.ex == nil {
+		sub.ex = e.startExecution(commandName, cmdConfig, client, fullCommand, run)
+	} else {
+		logger.Debugf("Command %s attached to running execution of: %s", sub.id, fullCommand)
+	}
+
+	e.storeCommand(sub)
+	e.connect(ctx, sub, 0, outputChan)
+
+	return sub.id
+}
+
+// Attach resumes streaming a command of the session to outputChan, starting
+// after the output with seq lastSeq. It reports false when the command does
+// not exist or has completed and been forgotten.
+func (e *Executor) Attach(ctx context.Context, commandID, sessionID string, lastSeq int64, outputChan chan<- Output) bool {
+	e.commandsLock.RLock()
+	sub, exists := e.activeCommands[commandID]
+	e.commandsLock.RUnlock()
+
+	if !exists || sub.sessionID != sessionID {
+		return false
+	}
+	return e.connect(ctx, sub, lastSeq, outputChan)
+}
+
+// connect streams a subscription to outputChan until it completes or ctx is
+// cancelled, taking over from a previous connection that is still open.
+// outputChan is closed at the end.
+func (e *Executor) connect(ctx context.Context, sub *subscription, after int64, outputChan chan<- Output) bool {
+	streamCtx, cancelStream := context.WithCancel(ctx)
+
+	e.commandsLock.Lock()
+	if e.activeCommands[sub.id] != sub {
+		e.commandsLock.Unlock()
+		cancelStream()
+		return false
+	}
+	if sub.detachTimer != nil {
+		sub.detachTimer.Stop()
+		sub.detachTimer = nil
+	}
+	if sub.cancelStream != nil {
+		sub.cancelStream()
+	}
+	sub.cancelStream = cancelStream
+	sub.streams++
+	stream := sub.streams
+	e.commandsLock.Unlock()
+
+	go func() {
+		defer close(outputChan)
+		defer cancelStream()
+
+		if sub.ex.stream(streamCtx, sub, after, outputChan) {
+			e.removeCommand(sub)
+			return
+		}
+		e.detach(sub, stream)
+	}()
 
-			// Use the first resolved IP
-			resolvedIP := ips[0].String()
+	return true
+}
+
+// detach handles the loss of a subscription's connection. Unless another
+// connection took over, the subscription leaves its execution once the
+// reattach window passes without the client coming back.
+func (e *Executor) detach(sub *subscription, stream int) {
+	e.commandsLock.Lock()
+	defer e.commandsLock.Unlock()
+
+	if e.activeCommands[sub.id] != sub || sub.streams != stream {
+		return
+	}
+	sub.cancelStream = nil
+
+	window := time.Duration(e.config.Execution.ReattachWindow) * time.Second
+	if window <= 0 {
+		delete(e.activeCommands, sub.id)
+		sub.ex.leave(sub, context.Canceled)
+		return
+	}
+
+	sub.detachTimer = time.AfterFunc(window, func() {
+		e.commandsLock.Lock()
+		if e.activeCommands[sub.id] != sub || sub.streams != stream {
+			e.commandsLock.Unlock()
+			return
+		}
+		delete(e.activeCommands, sub.id)
+		e.commandsLock.Unlock()
+
+		logger.Debugf("Command %s was not reattached in time", sub.id)
+		sub.ex.leave(sub, context.Canceled)
+	})
+}
+
+// attachShared returns a running execution of the same command line when
+// the command allows sharing and the execution started within its window
+func (e *Executor) attachShared(commandName string, cmdConfig config.CommandTemplate, fullCommand string) *execution {
+	if cmdConfig.ShareWindow <= 0 {
+		return nil
+	}
+
+	e.sharedLock.Lock()
+	defer e.sharedLock.Unlock()
 
-			// Reconstruct target with IP and port if present
-			if port != "" {
-				// Check if it's IPv6 (needs brackets with port)
-				if strings.Contains(resolvedIP, ":") {
-					resolvedTarget = fmt.Sprintf("[%s]:%s", resolvedIP, port)
-				} else {
-					resolvedTarget = fmt.Sprintf("%s:%s", resolvedIP, port)
+	ex, exists := e.shared[shareKey(commandName, fullCommand)]
+	if !exists || time.Since(ex.createdAt) > time.Duration(cmdConfig.ShareWindow)*time.Second {
+		return nil
+	}
+	if !ex.attach() {
+		return nil
+	}
+	return ex
+}
+
+// startExecution runs a command in a new execution. The execution is cancelled
+// by Shutdown, when all its subscribers leave, and by the command's own
+// timeout and output limits.
+func (e *Executor) startExecution(commandName string, cmdConfig config.CommandTemplate, client, fullCommand string, run runFunc) *execution {
+	key := ""
+	if cmdConfig.ShareWindow > 0 {
+		key = shareKey(commandName, fullCommand)
+	}
+	ex := newExecution(e.ctx, key, e.config.Execution.ReplayBuffer)
+
+	if key != "" {
+		e.sharedLock.Lock()
+		e.shared[key] = ex
+		e.sharedLock.Unlock()
+	}
+
+	limiter := &outputLimiter{
+		maxBytes:      cmdConfig.MaxOutputBytes,
+		maxLines:      cmdConfig.MaxLines,
+		maxLineLength: cmdConfig.MaxLineLength,
+		truncate:      func() { ex.cancel(errTruncated) },
+	}
+
+	e.running.Add(1)
+	go func() {
+		defer e.running.Done()
+		defer func() {
+			if key != "" {
+				e.sharedLock.Lock()
+				if e.shared[key] == ex {
+					delete(e.shared, key)
 				}
-			} else {
-				resolvedTarget = resolvedIP
+				e.sharedLock.Unlock()
 			}
+			ex.finish()
+		}()
+
+		// Wait for an execution slot
+		queued := false
+		release, err := e.scheduler.acquire(ex.ctx, commandName, cmdConfig.MaxConcurrent, client, func(status queueStatus) {
+			queued = true
+			ex.publish(queuedOutput(status))
+		})
+		if err != nil {
+			ex.publish(stoppedOutput(err))
+			return
+		}
+		defer release()
 
+		if queued {
+			ex.publish(Output{Event: "started"})
 		}
+
+		// The timeout only covers the time the command actually runs
+		runCtx, cancelTimeout := ex.ctx, context.CancelFunc(func() {})
+		if cmdConfig.Timeout > 0 {
+			runCtx, cancelTimeout = context.WithTimeoutCause(ex.ctx, time.Duration(cmdConfig.Timeout)*time.Second, errTimeout)
+		}
+		defer cancelTimeout()
+
+		run(runCtx, limiter, ex.publish)
+	}()
+
+	return ex
+}
+
+// shareKey identifies executions of the same rendered command line
+func shareKey(commandName, fullCommand string) string {
+	return commandName + "\x00" + fullCommand
+}
+
+// queuedOutput builds the event telling the client where its command waits
+func queuedOutput(status queueStatus) Output {
+	data := map[string]any{
+		"position": status.position,
+	}
+	if status.eta > 0 {
+		data["eta"] = int(status.eta.Round(time.Second).Seconds())
 	}
+	return Output{
+		Event: "queued",
+		Data:  data,
+	}
+}
+
+// buildCommand renders the command template into the arguments to execute.
+// Templates only run through the shell when the command opts in with shell: true.
+func buildCommand(template config.CommandLine, shell bool, vars map[string]string) ([]string, error) {
+	if shell {
+		script, err := template.RenderShell(vars)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"/bin/bash", "-c", script}, nil
+	}
+	return template.RenderArgs(vars)
+}
 
-	fullCommand := cmdConfig.Template
-	if resolvedTarget != "" && !cmdConfig.IgnoreTarget {
-		fullCommand = cmdConfig.Template + " " + resolvedTarget
+// ipVersionFlag returns the flag ping and traceroute style tools take to
+// force an IP version, or an empty string for auto
+func ipVersionFlag(ipVersion string) string {
+	switch ipVersion {
+	case "ipv4":
+		return "-4"
+	case "ipv6":
+		return "-6"
+	default:
+		return ""
 	}
+}
 
-	commandID := generateCommandID(commandName, target, sessionID)
-	stopChan := make(chan bool, 1)
+// resolveHost resolves a domain name to its first IP address of the
+// selected IP version. IP addresses are returned as they are.
+func resolveHost(host, ipVersion string) (string, error) {
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
 
-	e.storeCommand(commandID, fullCommand, stopChan)
+	var version validator.IPVersion
+	switch ipVersion {
+	case "ipv4":
+		version = validator.IPVersionIPv4
+	case "ipv6":
+		version = validator.IPVersionIPv6
+	default:
+		version = validator.IPVersionAuto
+	}
 
-	go e.runCommand(commandID, fullCommand, stopChan, outputChan)
+	ips, err := validator.ResolveDomainWithVersion(host, version)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve domain %s: %v", host, err)
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("No IP addresses found for domain: %s", host)
+	}
+	return ips[0].String(), nil
+}
 
-	return commandID
+// joinHostPort rebuilds a target from an IP and an optional port
+func joinHostPort(ip, port string) string {
+	if port == "" {
+		return ip
+	}
+	return net.JoinHostPort(ip, port)
 }
 
 // extractHostPort extracts host and port from target string
@@ -164,190 +550,456 @@ func extractHostPort(target string) (host, port string) {
 	return target[:lastColon], target[lastColon+1:]
 }
 
-func (e *Executor) runCommand(commandID, fullCommand string, stopChan <-chan bool, outputChan chan<- Output) {
-	defer func() {
-		e.removeCommand(commandID)
-		close(outputChan)
-	}()
-
-	cmd := e.createCommand(fullCommand)
+// runCommand runs a command with its output connected to pipes, or to a
+// pseudo-terminal when the command is configured with pty, and streams it
+// in the command's stream and ansi modes until it exits or ctx is cancelled
+func (e *Executor) runCommand(ctx context.Context, args []string, cmdConfig config.CommandTemplate, limiter *outputLimiter, emit func(Output)) {
+	cmd := e.createCommand(args)
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		outputChan <- Output{
-			Error:      "Failed to get stdout pipe: " + err.Error(),
-			IsComplete: true,
-			IsError:    true,
-		}
-		return
+	var results *outputParser
+	if cmdConfig.Parser != "" {
+		results = newOutputParser(cmdConfig.Parser)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		outputChan <- Output{
-			Error:      "Failed to get stderr pipe: " + err.Error(),
-			IsComplete: true,
-			IsError:    true,
+	// streamOutput streams one of the command's outputs, each with a
+	// styler of its own in the spans mode. The parser reads the text of
+	// the standard output.
+	streamOutput := func(r io.Reader, done chan<- bool, isStderr bool) {
+		emit := emit
+		if results != nil && !isStderr {
+			emit = results.wrap(emit)
+		}
+		switch cmdConfig.ANSI {
+		case config.ANSIStrip:
+			r = newTerminalReader(r, false)
+		case config.ANSISpans:
+			r = newTerminalReader(r, true)
+			emit = styledEmit(emit)
+		}
+		if cmdConfig.StreamMode == config.StreamRaw {
+			e.streamRaw(r, emit, done, limiter, isStderr)
+		} else {
+			e.streamLines(r, emit, done, limiter, isStderr)
 		}
-		return
 	}
 
-	if err := cmd.Start(); err != nil {
-		outputChan <- Output{
-			Error:      "Failed to start command: " + err.Error(),
-			IsComplete: true,
-			IsError:    true,
+	var (
+		startedAt time.Time
+		done      = make(chan error, 1)
+		group     = &processGroup{cmd: cmd}
+	)
+	if cmdConfig.PTY {
+		// Terminals expect to know their type, the window size is fixed
+		cmd.Env = append(os.Environ(), "TERM=xterm")
+		terminal, err := startPTY(cmd, ptyColumns, ptyRows)
+		if err != nil {
+			emit(Output{
+				Error:      "Failed to start command: " + err.Error(),
+				IsComplete: true,
+				IsError:    true,
+			})
+			return
 		}
-		return
-	}
+		startedAt = time.Now()
 
-	e.commandsLock.Lock()
-	e.activeCommands[commandID] = &ActiveCommand{
-		Cmd:         cmd,
-		FullCommand: fullCommand,
-	}
-	e.commandsLock.Unlock()
+		outputDone := make(chan bool, 1)
+		go streamOutput(terminal, outputDone, false)
 
-	done := make(chan error, 1)
-	stdoutDone := make(chan bool, 1)
-	stderrDone := make(chan bool, 1)
-	stopped := make(chan bool, 1)
+		go func() {
+			// Processes left behind in another session may keep the
+			// terminal open, so its output is only drained for a moment
+			// once the command exited
+			group.exit()
+			err := cmd.Wait()
+			select {
+			case <-outputDone:
+			case <-time.After(ptyDrainTimeout):
+			}
+			terminal.Close()
+			done <- err
+		}()
+	} else {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			emit(Output{
+				Error:      "Failed to get stdout pipe: " + err.Error(),
+				IsComplete: true,
+				IsError:    true,
+			})
+			return
+		}
 
-	go e.streamOutput(stdout, outputChan, stdoutDone, stopped, false)
-	go e.streamOutput(stderr, outputChan, stderrDone, stopped, true)
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			emit(Output{
+				Error:      "Failed to get stderr pipe: " + err.Error(),
+				IsComplete: true,
+				IsError:    true,
+			})
+			return
+		}
 
-	go func() {
-		done <- cmd.Wait()
-	}()
+		if err := cmd.Start(); err != nil {
+			emit(Output{
+				Error:      "Failed to start command: " + err.Error(),
+				IsComplete: true,
+				IsError:    true,
+			})
+			return
+		}
+		startedAt = time.Now()
+
+		stdoutDone := make(chan bool, 1)
+		stderrDone := make(chan bool, 1)
+		go streamOutput(stdout, stdoutDone, false)
+		go streamOutput(stderr, stderrDone, true)
+
+		go func() {
+			// Wait closes the pipes, so both streams must be drained
+			// first, after killing what would keep them open
+			group.exit()
+			<-stdoutDone
+			<-stderrDone
+			done <- cmd.Wait()
+		}()
+	}
 
 	select {
-	case <-stopChan:
-		e.stopCommand(commandID)
-		// Signal streamOutput goroutines to stop
-		close(stopped)
-		// Wait for goroutines to finish
-		<-stdoutDone
-		<-stderrDone
-		outputChan <- Output{
-			Output:     "\n*** Stopped ***",
-			IsComplete: true,
-			IsStopped:  true,
+	case <-ctx.Done():
+		// Terminate and reap the process group; output written until it
+		// exits is still forwarded
+		e.stopCommand(group, done)
+		if results != nil {
+			results.sendResult(emit)
 		}
+		output := stoppedOutput(context.Cause(ctx))
+		output.Result = processResult(cmd, startedAt)
+		emit(output)
 		return
 	case err := <-done:
-		<-stdoutDone
-		<-stderrDone
-
+		reapProcessGroup(cmd)
+		result := processResult(cmd, startedAt)
+		if results != nil {
+			results.sendResult(emit)
+		}
 		if err != nil {
-			outputChan <- Output{
-				Output:     "Command failed: " + err.Error(),
+			emit(Output{
+				Error:      "Command failed: " + err.Error(),
 				IsComplete: true,
 				IsError:    true,
-			}
+				Result:     result,
+			})
 		} else {
-			outputChan <- Output{
+			emit(Output{
 				IsComplete: true,
-			}
+				Result:     result,
+			})
 		}
 		return
 	}
 }
 
-func (e *Executor) streamOutput(pipe interface{ Read([]byte) (int, error) }, outputChan chan<- Output, done chan<- bool, stopped <-chan bool, isStderr bool) {
+// processResult collects the exit status and resource usage of a command
+// whose process has been waited for
+func processResult(cmd *exec.Cmd, startedAt time.Time) *Result {
+	result := &Result{
+		ExitCode:   -1,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+
+	state := cmd.ProcessState
+	if state == nil {
+		return result
+	}
+
+	result.ExitCode = state.ExitCode()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		result.Signal = status.Signal().String()
+	}
+	result.UserTime = state.UserTime()
+	result.SystemTime = state.SystemTime()
+	result.MaxRSS = maxRSS(state)
+
+	logger.Infof("Command finished in %s with exit code %d (user %s, sys %s, max RSS %d KiB): %s",
+		result.Duration().Round(time.Millisecond), result.ExitCode,
+		result.UserTime.Round(time.Millisecond), result.SystemTime.Round(time.Millisecond),
+		result.MaxRSS/1024, strings.Join(cmd.Args, " "))
+
+	return result
+}
+
+// stoppedOutput builds the final output of a command that was cancelled
+// or never got to run
+func stoppedOutput(cause error) Output {
+	switch {
+	case errors.Is(cause, errQueueTimeout):
+		return Output{
+			Error:      "Timed out waiting for other commands to finish, please try again later",
+			IsComplete: true,
+			IsError:    true,
+			Reason:     ReasonQueueTimeout,
+		}
+	case errors.Is(cause, errTimeout):
+		return Output{
+			Output:     "\n*** Timed out ***",
+			IsComplete: true,
+			Reason:     ReasonTimeout,
+		}
+	case errors.Is(cause, errTruncated):
+		return Output{
+			Output:     "\n*** Output truncated ***",
+			IsComplete: true,
+			Reason:     ReasonTruncated,
+		}
+	default:
+		return Output{
+			Output:     "\n*** Stopped ***",
+			IsComplete: true,
+			IsStopped:  true,
+			Reason:     ReasonStopped,
+		}
+	}
+}
+
+// streamLines forwards output line by line. Lines longer than the limiter's
+// maximum line length are split.
+func (e *Executor) streamLines(pipe io.Reader, emit func(Output), done chan<- bool, limiter *outputLimiter, isStderr bool) {
 	defer func() { done <- true }()
 
-	scanner := bufio.NewScanner(pipe)
-	for scanner.Scan() {
-		select {
-		case <-stopped:
-			// Stop signal received, exit gracefully
+	bufferSize := limiter.maxLineLength
+	if bufferSize <= 0 {
+		bufferSize = 4096
+	}
+	reader := bufio.NewReaderSize(pipe, bufferSize)
+
+	var long []byte // Start of a line exceeding the buffer, when lines are not split
+	split := false  // Whether the last piece was split off a longer line
+	for {
+		piece, isPrefix, err := reader.ReadLine()
+		if err != nil {
 			return
-		default:
-			line := convertToUTF8(scanner.Text())
-			// Use select to avoid panic on closed channel
-			select {
-			case <-stopped:
-				return
-			case outputChan <- Output{
-				Output:     line,
-				IsError:    isStderr,
-				IsComplete: false,
-			}:
+		}
+		if isPrefix && limiter.maxLineLength <= 0 {
+			// Counted as it is read, so output without newlines cannot grow
+			// past the byte limit
+			if limiter.allow(len(piece), 0) {
+				long = append(long, piece...)
 			}
+			continue
+		}
+		size := len(piece) + 1
+		if long != nil {
+			piece = append(long, piece...)
+			long = nil
+		}
+
+		// A split line ending right at the limit leaves only its newline
+		wasSplit := split
+		split = isPrefix
+		if wasSplit && len(piece) == 0 {
+			continue
 		}
+
+		if !limiter.allow(size, 1) {
+			continue
+		}
+		emit(Output{
+			Output:     convertToUTF8(string(piece)),
+			IsError:    isStderr,
+			IsComplete: false,
+		})
 	}
 }
 
-func (e *Executor) createCommand(fullCommand string) *exec.Cmd {
-	for _, op := range shellOperators {
-		if strings.Contains(fullCommand, op) {
-			return exec.Command("/bin/bash", "-c", fullCommand)
+// streamRaw forwards output in chunks as soon as it is read, so progress
+// redrawn with carriage returns shows up live. Chunks never end within a
+// UTF-8 sequence.
+func (e *Executor) streamRaw(pipe io.Reader, emit func(Output), done chan<- bool, limiter *outputLimiter, isStderr bool) {
+	defer func() { done <- true }()
+
+	buf := make([]byte, rawChunkSize)
+	var pending []byte // Incomplete UTF-8 sequence held back from the last chunk
+	for {
+		n, err := pipe.Read(buf)
+		chunk := append(pending, buf[:n]...)
+		pending = nil
+		if err == nil {
+			cut := incompleteRuneStart(chunk)
+			pending = append(pending, chunk[cut:]...)
+			chunk = chunk[:cut]
+		}
+
+		if len(chunk) > 0 && limiter.allow(len(chunk), bytes.Count(chunk, []byte("\n"))) {
+			emit(Output{
+				Output:  convertToUTF8(string(chunk)),
+				IsError: isStderr,
+				Raw:     true,
+			})
+		}
+		if err != nil {
+			return
 		}
 	}
+}
 
-	parts := strings.Fields(fullCommand)
-	if len(parts) == 0 {
-		return nil
+// incompleteRuneStart returns the index at which b ends with an incomplete
+// UTF-8 sequence, or len(b) when it does not
+func incompleteRuneStart(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
 	}
-	return exec.Command(parts[0], parts[1:]...)
+	return len(b)
 }
 
-func (e *Executor) Stop(commandID string) bool {
-	e.stopLock.RLock()
-	stopChan, exists := e.stopSignals[commandID]
-	e.stopLock.RUnlock()
+func (e *Executor) createCommand(args []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	setProcessGroup(cmd)
+	return cmd
+}
 
-	if !exists {
+// Stop cancels a command of the session. Its stream receives an IsStopped
+// output once the process has been killed, or right away when the
+// execution is shared with others who keep it running.
+func (e *Executor) Stop(commandID, sessionID string) bool {
+	e.commandsLock.Lock()
+	sub, exists := e.activeCommands[commandID]
+	if !exists || sub.sessionID != sessionID {
+		e.commandsLock.Unlock()
 		return false
 	}
+	delete(e.activeCommands, commandID)
+	if sub.detachTimer != nil {
+		sub.detachTimer.Stop()
+	}
+	e.commandsLock.Unlock()
+
+	sub.ex.leave(sub, context.Canceled)
+	return true
+}
 
+// Shutdown cancels every running command and waits for their process
+// groups to be stopped, for at most the kill grace period and a margin
+func (e *Executor) Shutdown() {
+	e.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		e.running.Wait()
+		close(stopped)
+	}()
+
+	timeout := time.Duration(e.config.Execution.KillGracePeriod)*time.Second + shutdownMargin
 	select {
-	case stopChan <- true:
-		return true
-	default:
-		return false
+	case <-stopped:
+	case <-time.After(timeout):
+		logger.Warnf("Commands still running after %s, shutting down anyway", timeout)
 	}
 }
 
-func (e *Executor) storeCommand(commandID, fullCommand string, stopChan chan bool) {
+func (e *Executor) storeCommand(sub *subscription) {
 	e.commandsLock.Lock()
-	e.activeCommands[commandID] = &ActiveCommand{
-		FullCommand: fullCommand,
-	}
+	e.activeCommands[sub.id] = sub
 	e.commandsLock.Unlock()
-
-	e.stopLock.Lock()
-	e.stopSignals[commandID] = stopChan
-	e.stopLock.Unlock()
 }
 
-func (e *Executor) removeCommand(commandID string) {
+func (e *Executor) removeCommand(sub *subscription) {
 	e.commandsLock.Lock()
-	delete(e.activeCommands, commandID)
+	if e.activeCommands[sub.id] == sub {
+		delete(e.activeCommands, sub.id)
+	}
 	e.commandsLock.Unlock()
+}
 
-	e.stopLock.Lock()
-	delete(e.stopSignals, commandID)
-	e.stopLock.Unlock()
+// processGroup signals the process group of a command only until the
+// command is reaped, after which the group ID may belong to another group
+type processGroup struct {
+	cmd    *exec.Cmd
+	mu     sync.Mutex
+	exited bool
 }
 
-func (e *Executor) stopCommand(commandID string) {
-	e.commandsLock.RLock()
-	activeCmd, exists := e.activeCommands[commandID]
-	e.commandsLock.RUnlock()
+// exit waits for the command to exit and kills what it left running in its
+// group, such as children started in the background, before it is reaped.
+// Where a process cannot be waited for without reaping it, it returns at
+// once and the group is signalled as long as the command runs.
+func (g *processGroup) exit() {
+	if err := awaitExit(g.cmd); err != nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	killProcessGroup(g.cmd)
+	g.exited = true
+}
 
-	if !exists || activeCmd.Cmd == nil || activeCmd.Cmd.Process == nil {
+// signal signals the group with the given function unless the command has
+// exited
+func (g *processGroup) signal(signal func(*exec.Cmd) error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.exited {
+		return nil
+	}
+	return signal(g.cmd)
+}
+
+// stopCommand sends SIGTERM to the command's process group and escalates to
+// SIGKILL once the grace period has passed. It returns after the process has
+// been reaped through done.
+func (e *Executor) stopCommand(group *processGroup, done <-chan error) {
+	pid := group.cmd.Process.Pid
+	if err := group.signal(terminateProcessGroup); err != nil {
+		logger.Debugf("Failed to terminate process group %d: %v", pid, err)
+	}
+
+	grace := time.NewTimer(time.Duration(e.config.Execution.KillGracePeriod) * time.Second)
+	defer grace.Stop()
+
+	select {
+	case <-done:
+		reapProcessGroup(group.cmd)
 		return
+	case <-grace.C:
 	}
 
-	activeCmd.Cmd.Process.Kill()
+	if err := group.signal(killProcessGroup); err != nil {
+		logger.Warnf("Failed to kill process group %d: %v", pid, err)
+	}
+	<-done
+	reapProcessGroup(group.cmd)
 }
 
-func generateCommandID(command, target, sessionID string) string {
-	if target != "" {
-		return fmt.Sprintf("%s-%s-%s", command, target, sessionID)
+// allow accounts for a piece of output of the given size in bytes and lines
+// and reports whether it may be sent. The first piece over either limit
+// truncates the command.
+func (l *outputLimiter) allow(size, lines int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.exceeded {
+		return false
+	}
+
+	l.bytes += size
+	l.lines += lines
+
+	if (l.maxBytes > 0 && l.bytes > l.maxBytes) || (l.maxLines > 0 && l.lines > l.maxLines) {
+		l.exceeded = true
+		l.truncate()
+		return false
 	}
-	return fmt.Sprintf("%s-%s", command, sessionID)
+	return true
+}
+
+// generateCommandID returns a random ID, unique across all commands
+func generateCommandID() string {
+	return "cmd_" + rand.Text()
 }
 
 // convertToUTF8 converts the input string from GBK to UTF-8 if running on Windows
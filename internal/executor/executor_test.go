@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestUnsplitLineLimited(t *testing.T) {
+	// A line of 16 MiB without newline, with line splitting disabled
+	pipe := &countingReader{r: io.LimitReader(strings.NewReader(strings.Repeat("a", 16<<20)), 16<<20)}
+	readAtTruncation := -1
+	limiter := &outputLimiter{
+		maxBytes:      1 << 20,
+		maxLineLength: -1,
+		truncate:      func() { readAtTruncation = pipe.read },
+	}
+
+	var outputs []Output
+	done := make(chan bool, 1)
+	(&Executor{}).streamLines(pipe, func(output Output) { outputs = append(outputs, output) }, done, limiter, false)
+
+	if readAtTruncation < 0 || readAtTruncation > 2<<20 {
+		t.Errorf("truncated after reading %d bytes, want right past the 1 MiB limit", readAtTruncation)
+	}
+	if len(outputs) != 0 {
+		t.Errorf("got %d outputs, want the line dropped", len(outputs))
+	}
+}
+
+func TestLinesSplit(t *testing.T) {
+	// Readers buffer at least 16 bytes
+	pipe := bytes.NewReader([]byte("short\n" + strings.Repeat("b", 40) + "\n"))
+	limiter := &outputLimiter{maxLineLength: 16, truncate: func() {}}
+
+	var lines []string
+	done := make(chan bool, 1)
+	(&Executor{}).streamLines(pipe, func(output Output) { lines = append(lines, output.Output) }, done, limiter, false)
+
+	want := []string{"short", strings.Repeat("b", 16), strings.Repeat("b", 16), strings.Repeat("b", 8)}
+	if strings.Join(lines, ",") != strings.Join(want, ",") {
+		t.Errorf("got lines %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateProcessGroup kills the command and its child processes. Console
+// programs ignore taskkill without /F, so there is no graceful phase on
+// Windows.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return killProcessGroup(cmd)
+}
+
+// killProcessGroup forcibly kills the command and its child processes
+func killProcessGroup(cmd *exec.Cmd) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// reapProcessGroup is a no-op on Windows, which has no zombie processes
+func reapProcessGroup(cmd *exec.Cmd) {}
+
+// maxRSS is not available on Windows once the process has exited
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// startPTY is only implemented on Linux
+func startPTY(cmd *exec.Cmd, columns, rows int) (*os.File, error) {
+	return nil, errors.New("pseudo-terminals are not supported on this platform")
+}
@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"YALS/internal/parser"
+	"bytes"
+	"sync"
+)
+
+// outputParser feeds the standard output of a command to a parser line by
+// line, as the output is streamed, for the result event sent when the
+// command ends
+type outputParser struct {
+	name   string
+	parser parser.Parser
+	filter terminalReader // Only used to filter escape sequences out of the output
+	line   []byte         // Start of a line not complete yet
+	mu     sync.Mutex
+}
+
+func newOutputParser(name string) *outputParser {
+	newParser, _ := parser.Lookup(name)
+	return &outputParser{name: name, parser: newParser()}
+}
+
+// wrap returns an emit function feeding outputs to the parser before
+// passing them on
+func (o *outputParser) wrap(emit func(Output)) func(Output) {
+	return func(output Output) {
+		o.feed(output.Output, output.Raw)
+		emit(output)
+	}
+}
+
+// feed reads a line of output, or a chunk of it in raw mode
+func (o *outputParser) feed(text string, raw bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !raw {
+		text += "\n"
+	}
+	o.line = o.filter.filter(o.line, []byte(text))
+	for {
+		end := bytes.IndexByte(o.line, '\n')
+		if end < 0 {
+			return
+		}
+		o.parseLine(o.line[:end])
+		o.line = o.line[end+1:]
+	}
+}
+
+// parseLine passes a line to the parser as a terminal would end up showing
+// it, keeping what follows its last carriage return
+func (o *outputParser) parseLine(line []byte) {
+	if start := bytes.LastIndexByte(line, '\r'); start >= 0 {
+		line = line[start+1:]
+	}
+	o.parser.Line(string(line))
+}
+
+// sendResult emits the event describing what the parser found in the
+// output, unless it recognised none of it
+func (o *outputParser) sendResult(emit func(Output)) {
+	o.mu.Lock()
+	if len(o.line) > 0 {
+		o.parseLine(o.line)
+		o.line = nil
+	}
+	data := o.parser.Result()
+	o.mu.Unlock()
+
+	if data == nil {
+		return
+	}
+	data["parser"] = o.name
+	emit(Output{
+		Event: "result",
+		Data:  data,
+	})
+}
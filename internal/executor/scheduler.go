@@ -0,0 +1,247 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+var errQueueTimeout = errors.New("timed out waiting in queue")
+
+// scheduler bounds how many commands run at once, globally, per command and
+// per client. Jobs over a limit wait in per-client queues which are served
+// round-robin, so a single client cannot starve the others.
+type scheduler struct {
+	maxGlobal    int
+	maxPerClient int
+	queueTimeout time.Duration
+
+	running    int
+	perCommand map[string]int
+	perClient  map[string]int
+	queues     map[string][]*job
+	clients    []string      // Clients with queued jobs, in round-robin order
+	avgRuntime time.Duration // Moving average of command run times, for ETAs
+	mu         sync.Mutex
+}
+
+// job is a command waiting for, or holding, an execution slot
+type job struct {
+	command    string
+	client     string
+	maxCommand int
+	startedAt  time.Time
+	started    chan struct{}
+	status     chan queueStatus
+	release    sync.Once
+}
+
+// queueStatus is the place of a queued job
+type queueStatus struct {
+	position int
+	eta      time.Duration // Zero when no estimate is available yet
+}
+
+func newScheduler(maxGlobal, maxPerClient int, queueTimeout time.Duration) *scheduler {
+	return &scheduler{
+		maxGlobal:    maxGlobal,
+		maxPerClient: maxPerClient,
+		queueTimeout: queueTimeout,
+		perCommand:   make(map[string]int),
+		perClient:    make(map[string]int),
+		queues:       make(map[string][]*job),
+	}
+}
+
+// acquire blocks until the command may start and returns the function that
+// gives its slot back. While the job is queued, onQueued is called whenever
+// its position changes. It fails when ctx is cancelled or the queue timeout
+// passes first.
+func (s *scheduler) acquire(ctx context.Context, command string, maxCommand int, client string, onQueued func(queueStatus)) (func(), error) {
+	j := &job{
+		command:    command,
+		client:     client,
+		maxCommand: maxCommand,
+		started:    make(chan struct{}),
+		status:     make(chan queueStatus, 1),
+	}
+	release := func() { j.release.Do(func() { s.finish(j) }) }
+
+	s.mu.Lock()
+	// Jobs only skip the queue when none of the client's earlier jobs wait
+	if len(s.queues[client]) == 0 && s.canStart(j) {
+		s.start(j)
+		s.mu.Unlock()
+		return release, nil
+	}
+	s.queues[client] = append(s.queues[client], j)
+	if !slices.Contains(s.clients, client) {
+		s.clients = append(s.clients, client)
+	}
+	s.updateStatus()
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.queueTimeout > 0 {
+		timer := time.NewTimer(s.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	last := queueStatus{}
+	for {
+		select {
+		case <-j.started:
+			return release, nil
+		case status := <-j.status:
+			if status != last {
+				last = status
+				onQueued(status)
+			}
+		case <-ctx.Done():
+			s.abandon(j)
+			return nil, context.Cause(ctx)
+		case <-timeout:
+			s.abandon(j)
+			return nil, errQueueTimeout
+		}
+	}
+}
+
+// canStart reports whether a job fits within all limits
+func (s *scheduler) canStart(j *job) bool {
+	if s.maxGlobal > 0 && s.running >= s.maxGlobal {
+		return false
+	}
+	if j.maxCommand > 0 && s.perCommand[j.command] >= j.maxCommand {
+		return false
+	}
+	if s.maxPerClient > 0 && s.perClient[j.client] >= s.maxPerClient {
+		return false
+	}
+	return true
+}
+
+func (s *scheduler) start(j *job) {
+	s.running++
+	s.perCommand[j.command]++
+	s.perClient[j.client]++
+	j.startedAt = time.Now()
+	close(j.started)
+}
+
+// finish gives back the slot of a job that has started
+func (s *scheduler) finish(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running--
+	s.perCommand[j.command]--
+	if s.perCommand[j.command] == 0 {
+		delete(s.perCommand, j.command)
+	}
+	s.perClient[j.client]--
+	if s.perClient[j.client] == 0 {
+		delete(s.perClient, j.client)
+	}
+
+	runtime := time.Since(j.startedAt)
+	if s.avgRuntime == 0 {
+		s.avgRuntime = runtime
+	} else {
+		s.avgRuntime = (s.avgRuntime*4 + runtime) / 5
+	}
+
+	s.dispatch()
+	s.updateStatus()
+}
+
+// abandon removes a job that gave up waiting. A job that was started in
+// the meantime gives its slot back instead.
+func (s *scheduler) abandon(j *job) {
+	s.mu.Lock()
+	queue := s.queues[j.client]
+	if i := slices.Index(queue, j); i != -1 {
+		s.removeQueued(j.client, i)
+		s.updateStatus()
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	j.release.Do(func() { s.finish(j) })
+}
+
+func (s *scheduler) removeQueued(client string, i int) {
+	s.queues[client] = slices.Delete(s.queues[client], i, i+1)
+	if len(s.queues[client]) == 0 {
+		delete(s.queues, client)
+		s.clients = slices.DeleteFunc(s.clients, func(c string) bool { return c == client })
+	}
+}
+
+// dispatch starts queued jobs while slots are free. Clients take turns, and
+// each client's jobs start in the order they were queued.
+func (s *scheduler) dispatch() {
+	for {
+		startedAny := false
+		for i, client := range s.clients {
+			j := s.queues[client][0]
+			if !s.canStart(j) {
+				continue
+			}
+
+			s.removeQueued(client, 0)
+			s.start(j)
+
+			// Move the client to the back of the round
+			if len(s.queues[client]) > 0 {
+				s.clients = append(slices.Delete(s.clients, i, i+1), client)
+			}
+			startedAny = true
+			break
+		}
+		if !startedAny {
+			return
+		}
+	}
+}
+
+// updateStatus tells every queued job its position, counting the jobs that
+// would start before it when clients take turns
+func (s *scheduler) updateStatus() {
+	slots := s.maxGlobal
+	if slots <= 0 {
+		slots = 1
+	}
+
+	position := 0
+	for round := 0; ; round++ {
+		queuedInRound := false
+		for _, client := range s.clients {
+			queue := s.queues[client]
+			if round >= len(queue) {
+				continue
+			}
+			queuedInRound = true
+			position++
+
+			status := queueStatus{position: position}
+			if s.avgRuntime > 0 {
+				status.eta = s.avgRuntime * time.Duration((position+slots-1)/slots)
+			}
+
+			// Replace any status the job has not picked up yet
+			select {
+			case <-queue[round].status:
+			default:
+			}
+			queue[round].status <- status
+		}
+		if !queuedInRound {
+			return
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"io"
+	"strconv"
+	"time"
+)
+
+// Window size of the pseudo-terminals commands run in
+const (
+	ptyColumns = 120
+	ptyRows    = 40
+)
+
+// ptyDrainTimeout is how long the output of a pseudo-terminal is read after
+// its command exited, while other processes still hold it open
+const ptyDrainTimeout = 2 * time.Second
+
+// maxSequenceParams is the length at which the parameters of a control
+// sequence are cut, enough for any color setting
+const maxSequenceParams = 64
+
+// terminalReader filters output written for a terminal down to text:
+// escape sequences and control characters are removed, except for cursor
+// forward moves, which become spaces, and the CRLF line endings of
+// terminals become newlines. Lone carriage returns are kept for raw
+// streaming. With keepSGR, the sequences setting colors and text attributes
+// are kept whole, for a styler to turn into spans.
+type terminalReader struct {
+	r       io.Reader
+	buf     []byte
+	text    []byte // Filtered text not read yet
+	err     error  // Error of the underlying reader, returned once text is read
+	keepSGR bool
+	state   terminalState
+	params  []byte // Parameters of the control sequence being read
+	pending bool   // A carriage return was read, not yet known to end a line
+}
+
+type terminalState int
+
+const (
+	stateText         terminalState = iota
+	stateEscape                     // After ESC
+	stateIntermediate               // After ESC and intermediate bytes
+	stateCSI                        // In a control sequence, ESC [
+	stateString                     // In a string sequence such as OSC, until ST or BEL
+	stateStringEscape               // After ESC within a string sequence
+)
+
+func newTerminalReader(r io.Reader, keepSGR bool) *terminalReader {
+	return &terminalReader{r: r, buf: make([]byte, rawChunkSize), keepSGR: keepSGR}
+}
+
+func (t *terminalReader) Read(p []byte) (int, error) {
+	for len(t.text) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		n, err := t.r.Read(t.buf)
+		t.text = t.filter(t.text[:0], t.buf[:n])
+		if err != nil {
+			if t.pending {
+				t.text = append(t.text, '\r')
+				t.pending = false
+			}
+			t.err = err
+		}
+	}
+	n := copy(p, t.text)
+	t.text = t.text[n:]
+	return n, nil
+}
+
+// filter appends the text of b to out
+func (t *terminalReader) filter(out, b []byte) []byte {
+	for _, c := range b {
+		switch t.state {
+		case stateText:
+			if t.pending {
+				t.pending = false
+				if c != '\n' {
+					out = append(out, '\r')
+				}
+			}
+			switch {
+			case c == 0x1b:
+				t.state = stateEscape
+			case c == '\r':
+				t.pending = true
+			case c == '\n' || c == '\t' || c >= 0x20 && c != 0x7f:
+				out = append(out, c)
+			}
+
+		case stateEscape:
+			switch {
+			case c == '[':
+				t.state = stateCSI
+				t.params = t.params[:0]
+			case c == ']' || c == 'P' || c == '^' || c == '_' || c == 'X':
+				t.state = stateString
+			case c >= 0x20 && c <= 0x2f:
+				t.state = stateIntermediate
+			default:
+				t.state = stateText
+			}
+
+		case stateIntermediate:
+			if c < 0x20 || c > 0x2f {
+				t.state = stateText
+			}
+
+		case stateCSI:
+			switch {
+			case c >= 0x40 && c <= 0x7e:
+				t.state = stateText
+				switch {
+				case c == 'C':
+					out = append(out, cursorForward(t.params)...)
+				case c == 'm' && t.keepSGR:
+					out = append(append(append(out, 0x1b, '['), t.params...), 'm')
+				}
+			case c >= 0x20 && c <= 0x3f:
+				if len(t.params) < maxSequenceParams {
+					t.params = append(t.params, c)
+				}
+			default:
+				// Malformed, the sequence is dropped
+				t.state = stateText
+			}
+
+		case stateString:
+			switch c {
+			case 0x07:
+				t.state = stateText
+			case 0x1b:
+				t.state = stateStringEscape
+			}
+
+		case stateStringEscape:
+			t.state = stateString
+			if c == '\\' {
+				t.state = stateText
+			}
+		}
+	}
+	return out
+}
+
+// cursorForward returns the spaces a cursor forward move with the given
+// parameters skips, at most a line's worth
+func cursorForward(params []byte) []byte {
+	n, err := strconv.Atoi(string(params))
+	if err != nil || n < 1 {
+		n = 1
+	}
+	n = min(n, ptyColumns)
+	spaces := make([]byte, n)
+	for i := range spaces {
+		spaces[i] = ' '
+	}
+	return spaces
+}
@@ -0,0 +1,37 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// awaitExit blocks until the command has exited without reaping it, so that
+// its PID, and with it the ID of its process group, cannot be reused until
+// cmd.Wait
+func awaitExit(cmd *exec.Cmd) error {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(kq)
+
+	var change syscall.Kevent_t
+	syscall.SetKevent(&change, cmd.Process.Pid, syscall.EVFILT_PROC, syscall.EV_ADD|syscall.EV_ONESHOT)
+	change.Fflags = syscall.NOTE_EXIT
+	events := make([]syscall.Kevent_t, 1)
+	for {
+		_, err := syscall.Kevent(kq, []syscall.Kevent_t{change}, events, nil)
+		switch err {
+		case nil, syscall.ESRCH:
+			// The command is not reaped yet, so it can only be missing
+			// because it already exited
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			return err
+		}
+	}
+}
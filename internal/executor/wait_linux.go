@@ -0,0 +1,29 @@
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// pPID is the idtype of waitid selecting a single process
+const pPID = 1
+
+// awaitExit blocks until the command has exited without reaping it, so that
+// its PID, and with it the ID of its process group, cannot be reused until
+// cmd.Wait
+func awaitExit(cmd *exec.Cmd) error {
+	var info [128]byte // siginfo_t, which is not read
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(cmd.Process.Pid),
+			uintptr(unsafe.Pointer(&info)), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			continue
+		default:
+			return errno
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package executor
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// awaitExit is not supported here: waiting for a process reaps it
+func awaitExit(cmd *exec.Cmd) error {
+	return errors.ErrUnsupported
+}
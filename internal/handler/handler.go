@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,17 +15,16 @@ import (
 	"YALS/internal/config"
 	"YALS/internal/executor"
 	"YALS/internal/logger"
+	"YALS/internal/probe"
 	"YALS/internal/utils"
 	"YALS/internal/validator"
 )
 
 type Handler struct {
-	server         *config.ServerInfo
-	executor       *executor.Executor
-	activeCommands map[string]chan bool
-	commandsLock   sync.RWMutex
-	webDir         string
-	rateLimiter    *RateLimiter
+	server      *config.ServerInfo
+	executor    *executor.Executor
+	webDir      string
+	rateLimiter *RateLimiter
 }
 
 type RateLimiter struct {
@@ -74,8 +75,11 @@ type CommandsListResponse struct {
 }
 
 type CommandTemplate struct {
-	Name         string `json:"name"`
-	IgnoreTarget bool   `json:"ignore_target"`
+	Name         string                `json:"name"`
+	IgnoreTarget bool                  `json:"ignore_target"`
+	TargetType   string                `json:"target_type"`
+	IPVersions   []string              `json:"ip_versions"`
+	Params       []config.CommandParam `json:"params,omitempty"`
 }
 
 type AppConfigResponse struct {
@@ -97,16 +101,31 @@ type NodeResponse struct {
 }
 
 type ExecRequest struct {
-	Agent     string `json:"agent"`
-	Command   string `json:"command"`
-	Target    string `json:"target"`
-	IPVersion string `json:"ip_version"`
+	Agent     string         `json:"agent"`
+	Command   string         `json:"command"`
+	Target    string         `json:"target"`
+	IPVersion string         `json:"ip_version"`
+	Params    map[string]any `json:"params,omitempty"`
 }
 
 type StopRequest struct {
 	CommandID string `json:"command_id"`
 }
 
+var ipVersionNames = map[string]string{
+	"ipv4": "IPv4",
+	"ipv6": "IPv6",
+}
+
+// targetDescriptions describe the targets each kind of command takes
+var targetDescriptions = map[probe.TargetKind]string{
+	probe.TargetHost:  "an IP address or domain name",
+	probe.TargetName:  "an IP address or domain name",
+	probe.TargetURL:   "an http or https URL",
+	probe.TargetQuery: "an IP address, prefix, AS number or domain name",
+	probe.TargetList:  "a list of IP addresses, domain names or prefixes",
+}
+
 func NewHandler(serverInstance *config.ServerInfo, executor *executor.Executor, pingInterval, pongWait time.Duration) *Handler {
 	cfg := config.GetConfig()
 
@@ -118,10 +137,9 @@ func NewHandler(serverInstance *config.ServerInfo, executor *executor.Executor,
 	}
 
 	return &Handler{
-		server:         serverInstance,
-		executor:       executor,
-		activeCommands: make(map[string]chan bool),
-		rateLimiter:    rateLimiter,
+		server:      serverInstance,
+		executor:    executor,
+		rateLimiter: rateLimiter,
 	}
 }
 
@@ -133,6 +151,7 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux, webDir string) {
 	mux.HandleFunc("/api/node", h.handleGetNodes)
 	mux.HandleFunc("/api/exec", h.handleExecCommand)
 	mux.HandleFunc("/api/stop", h.handleStopCommand)
+	mux.HandleFunc("/api/attach", h.handleAttachCommand)
 
 	fs := http.FileServer(http.Dir(webDir))
 	mux.Handle("/assets/", fs)
@@ -202,6 +221,9 @@ func (h *Handler) handleGetNodes(w http.ResponseWriter, r *http.Request) {
 		commandsSlice = append(commandsSlice, CommandTemplate{
 			Name:         cmd.Name,
 			IgnoreTarget: cmd.IgnoreTarget,
+			TargetType:   cmd.TargetType,
+			IPVersions:   cmd.IPVersions,
+			Params:       cmd.Params,
 		})
 	}
 
@@ -269,32 +291,56 @@ func (h *Handler) handleExecCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ipVersion := req.IPVersion
+	if ipVersion == "" {
+		ipVersion = "auto"
+	}
+
 	if !cmdConfig.IgnoreTarget {
 		inputType := validator.ValidateInput(req.Target)
-		if inputType == validator.InvalidInput {
-			h.sendSSEError(w, flusher, "Invalid target: must be an IP address or domain name")
+		if kind := cmdConfig.TargetKind(); !acceptsTarget(kind, inputType) {
+			h.sendSSEError(w, flusher, "Invalid target: must be "+targetDescriptions[kind])
+			return
+		}
+
+		if !cmdConfig.SupportsIPVersion(ipVersion) {
+			h.sendSSEError(w, flusher, fmt.Sprintf("IP version %s is not supported by command %s", ipVersion, req.Command))
+			return
+		}
+
+		literalVersion := validator.LiteralIPVersion(req.Target)
+		if literalVersion != validator.IPVersionAuto && ipVersion != "auto" && string(literalVersion) != ipVersion {
+			h.sendSSEError(w, flusher, fmt.Sprintf("Invalid target: %s is not an %s address", req.Target, ipVersionNames[ipVersion]))
 			return
 		}
 	}
 
-	ipVersion := req.IPVersion
-	if ipVersion == "" {
-		ipVersion = "auto"
+	params, err := validator.ValidateParams(cmdConfig.Params, req.Params)
+	if err != nil {
+		h.sendSSEError(w, flusher, "Invalid parameters: "+err.Error())
+		return
 	}
 
+	// The command is streamed for as long as the request lasts; a client
+	// whose connection dropped can pick it up again through /api/attach
 	outputChan := make(chan executor.Output, 100)
-	commandID := h.executor.ExecuteWithIPVersion(req.Command, req.Target, sessionID, ipVersion, outputChan)
+	// Sessions are handed out freely, so concurrency is limited per address
+	commandID := h.executor.ExecuteWithIPVersion(r.Context(), req.Command, req.Target, sessionID, remoteHost(r), ipVersion, params, outputChan)
 
 	if commandID == "" {
-		h.sendSSEError(w, flusher, "Failed to execute command")
+		errorMsg := "Failed to execute command"
+		select {
+		case output := <-outputChan:
+			if output.Error != "" {
+				errorMsg = output.Error
+			}
+		default:
+		}
+		h.sendSSEError(w, flusher, errorMsg)
 		return
 	}
 
-	stopChan := make(chan bool, 1)
-	h.setActiveCommand(commandID, stopChan)
-	defer h.removeActiveCommand(commandID)
-
-	logger.Infof("Client [%s] executing command: %s", clientIP, commandID)
+	logger.Infof("Client [%s] executing command %s: %s %s", clientIP, commandID, req.Command, req.Target)
 
 	h.sendSSEMessage(w, flusher, map[string]any{
 		"type":       "output",
@@ -302,55 +348,195 @@ func (h *Handler) handleExecCommand(w http.ResponseWriter, r *http.Request) {
 		"success":    true,
 	})
 
+	h.streamOutputs(w, flusher, outputChan)
+}
+
+func (h *Handler) handleAttachCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session_id")
+	if !h.validateSessionID(sessionID) {
+		http.Error(w, "Invalid or missing session_id", http.StatusUnauthorized)
+		return
+	}
+
+	commandID := r.URL.Query().Get("command_id")
+	if commandID == "" {
+		http.Error(w, "Missing command_id", http.StatusBadRequest)
+		return
+	}
+
+	// Resume after the last output the client received, or from the start
+	var lastSeq int64
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		seq, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || seq < 0 {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastSeq = seq
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	clientIP := h.getRealIP(r)
+
+	outputChan := make(chan executor.Output, 100)
+	if !h.executor.Attach(r.Context(), commandID, sessionID, lastSeq, outputChan) {
+		logger.Warnf("Client [%s] attempted to attach to non-existent command: %s", clientIP, commandID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success": false,
+			"error":   "Command not found or already completed",
+		})
+		return
+	}
+
+	logger.Infof("Client [%s] reattached to command %s after event %d", clientIP, commandID, lastSeq)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	h.sendSSEMessage(w, flusher, map[string]any{
+		"type":       "attached",
+		"command_id": commandID,
+		"success":    true,
+	})
+
+	h.streamOutputs(w, flusher, outputChan)
+}
+
+// streamOutputs sends a command's outputs as SSE messages until it
+// completes. Each output's seq is sent as the event ID of its last message,
+// which clients pass back as Last-Event-ID when reattaching.
+func (h *Handler) streamOutputs(w http.ResponseWriter, flusher http.Flusher, outputChan <-chan executor.Output) {
 	for output := range outputChan {
-		if output.IsStopped {
-			h.sendSSEMessage(w, flusher, map[string]any{
+		messages, complete := outputMessages(output)
+		if complete && output.Result != nil {
+			addResult(messages[len(messages)-1], output.Result)
+		}
+		for i, message := range messages {
+			var id int64
+			if i == len(messages)-1 {
+				id = output.Seq
+			}
+			h.sendSSEEvent(w, flusher, id, message)
+		}
+		if complete {
+			return
+		}
+	}
+}
+
+// addResult adds the exit status, timing and resource usage of the
+// command's process to its complete message. Times are in seconds.
+func addResult(message map[string]any, result *executor.Result) {
+	message["exit_code"] = result.ExitCode
+	if result.Signal != "" {
+		message["signal"] = result.Signal
+	}
+	message["started_at"] = result.StartedAt.Format(time.RFC3339Nano)
+	message["finished_at"] = result.FinishedAt.Format(time.RFC3339Nano)
+	message["duration"] = result.Duration().Round(time.Millisecond).Seconds()
+	message["user_time"] = result.UserTime.Round(time.Millisecond).Seconds()
+	message["system_time"] = result.SystemTime.Round(time.Millisecond).Seconds()
+	if result.MaxRSS > 0 {
+		message["max_rss"] = result.MaxRSS
+	}
+}
+
+// outputMessages converts an output to the SSE messages sent for it and
+// reports whether it completes the command
+func outputMessages(output executor.Output) ([]map[string]any, bool) {
+	if output.IsStopped {
+		return []map[string]any{
+			{
 				"type":    "output",
 				"output":  "\n*** Stopped ***",
 				"stopped": true,
-			})
-			h.sendSSEMessage(w, flusher, map[string]any{
+			},
+			{
 				"type":    "complete",
 				"success": false,
 				"stopped": true,
+				"reason":  output.Reason,
+			},
+		}, true
+	}
+
+	// Cut short by the command's limits or the queue
+	if output.IsComplete && output.Reason != "" {
+		messages := []map[string]any{}
+		if output.Output != "" {
+			messages = append(messages, map[string]any{
+				"type":   "output",
+				"output": output.Output,
 			})
-			break
 		}
+		message := map[string]any{
+			"type":    "complete",
+			"success": false,
+			"reason":  output.Reason,
+		}
+		if output.Error != "" {
+			message["error"] = output.Error
+		}
+		return append(messages, message), true
+	}
 
-		if output.IsComplete {
-			if output.IsError {
-				h.sendSSEMessage(w, flusher, map[string]any{
-					"type":    "complete",
-					"success": false,
-					"error":   output.Error,
-				})
-			} else {
-				if output.Output != "" {
-					h.sendSSEMessage(w, flusher, map[string]any{
-						"type":   "output",
-						"output": output.Output,
-					})
-				}
-				h.sendSSEMessage(w, flusher, map[string]any{
-					"type":    "complete",
-					"success": true,
-				})
-			}
-			break
+	if output.Event != "" {
+		message := map[string]any{"type": output.Event}
+		for key, value := range output.Data {
+			message[key] = value
 		}
+		return []map[string]any{message}, false
+	}
 
+	if output.IsComplete {
 		if output.IsError {
-			h.sendSSEMessage(w, flusher, map[string]any{
-				"type":  "error",
-				"error": output.Error,
-			})
-		} else {
-			h.sendSSEMessage(w, flusher, map[string]any{
+			return []map[string]any{{
+				"type":    "complete",
+				"success": false,
+				"error":   output.Error,
+			}}, true
+		}
+		messages := []map[string]any{}
+		if output.Output != "" {
+			messages = append(messages, map[string]any{
 				"type":   "output",
 				"output": output.Output,
 			})
 		}
+		return append(messages, map[string]any{
+			"type":    "complete",
+			"success": true,
+		}), true
+	}
+
+	message := map[string]any{
+		"type":   "output",
+		"output": output.Output,
+	}
+	if output.IsError {
+		message["stderr"] = true
+	}
+	if output.Raw {
+		message["raw"] = true
+	}
+	if output.Spans != nil {
+		message["spans"] = output.Spans
 	}
+	return []map[string]any{message}, false
 }
 
 func (h *Handler) handleStopCommand(w http.ResponseWriter, r *http.Request) {
@@ -378,7 +564,7 @@ func (h *Handler) handleStopCommand(w http.ResponseWriter, r *http.Request) {
 
 	clientIP := h.getRealIP(r)
 
-	if h.stopActiveCommand(req.CommandID) {
+	if h.executor.Stop(req.CommandID, sessionID) {
 		logger.Infof("Client [%s] sent stop signal for command: %s", clientIP, req.CommandID)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
@@ -397,11 +583,19 @@ func (h *Handler) handleStopCommand(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) sendSSEMessage(w http.ResponseWriter, flusher http.Flusher, data map[string]any) {
+	h.sendSSEEvent(w, flusher, 0, data)
+}
+
+// sendSSEEvent sends a message with an event ID, omitted when zero
+func (h *Handler) sendSSEEvent(w http.ResponseWriter, flusher http.Flusher, id int64, data map[string]any) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		logger.Errorf("Failed to marshal SSE message: %v", err)
 		return
 	}
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
 	fmt.Fprintf(w, "data: %s\n\n", jsonData)
 	flusher.Flush()
 }
@@ -431,6 +625,15 @@ func (h *Handler) getRealIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+// remoteHost returns the address a request came from, without its port.
+// Unlike getRealIP, it ignores proxy headers, which clients can set freely.
+func remoteHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
 func GenerateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
 
@@ -459,30 +662,6 @@ func (h *Handler) validateSessionID(sessionID string) bool {
 	return true
 }
 
-func (h *Handler) setActiveCommand(commandID string, stopChan chan bool) {
-	h.commandsLock.Lock()
-	h.activeCommands[commandID] = stopChan
-	h.commandsLock.Unlock()
-}
-
-func (h *Handler) removeActiveCommand(commandID string) {
-	h.commandsLock.Lock()
-	delete(h.activeCommands, commandID)
-	h.commandsLock.Unlock()
-}
-
-func (h *Handler) stopActiveCommand(commandID string) bool {
-	h.commandsLock.Lock()
-	defer h.commandsLock.Unlock()
-
-	if stopChan, exists := h.activeCommands[commandID]; exists {
-		close(stopChan)
-		delete(h.activeCommands, commandID)
-		return true
-	}
-	return false
-}
-
 func (rl *RateLimiter) checkRateLimit(sessionID string) bool {
 	if !rl.enabled {
 		return true
@@ -539,3 +718,19 @@ func (rl *RateLimiter) getRemainingTime(sessionID string) time.Duration {
 	}
 	return remaining
 }
+
+// acceptsTarget reports whether a kind of command takes a type of input
+func acceptsTarget(kind probe.TargetKind, inputType validator.InputType) bool {
+	switch kind {
+	case probe.TargetURL:
+		return inputType == validator.URL
+	case probe.TargetQuery:
+		return inputType == validator.IPAddress || inputType == validator.Domain ||
+			inputType == validator.Prefix || inputType == validator.ASN
+	case probe.TargetList:
+		return inputType == validator.IPAddress || inputType == validator.Domain ||
+			inputType == validator.Prefix || inputType == validator.List
+	default:
+		return inputType == validator.IPAddress || inputType == validator.Domain
+	}
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lines of mtr report output
+var (
+	// HOST: lg.example.net              Loss%   Snt   Last   Avg  Best  Wrst StDev
+	mtrHeader = regexp.MustCompile(`^HOST:\s+\S+\s+(.+)$`)
+	// 2.|-- AS64500  r1.example.net (192.0.2.1)  0.0%    10    5.1   5.2   5.0   5.6   0.2
+	mtrHop = regexp.MustCompile(`^\s*(\d+)\.(?:\|--|\s)\s*(.+)$`)
+	// |  `|-- 192.0.2.2
+	mtrMultipath = regexp.MustCompile("^\\s*\\|\\s+`\\|-- (\\S+)")
+)
+
+// mtrColumns names the columns of mtr reports the way the native mtr
+// backend names its statistics. Other columns are named in lower case.
+var mtrColumns = map[string]string{
+	"Loss%": "loss",
+	"Drop":  "dropped",
+	"Rcv":   "received",
+	"Snt":   "sent",
+	"Wrst":  "worst",
+	"Jttr":  "jitter",
+}
+
+// mtrDefaultColumns are the columns of reports when mtr is not told to
+// print others with -o
+var mtrDefaultColumns = []string{"Loss%", "Snt", "Last", "Avg", "Best", "Wrst", "StDev"}
+
+// mtrParser reads the output of mtr --report
+type mtrParser struct {
+	columns []string
+	hops    []map[string]any
+}
+
+func (p *mtrParser) Line(line string) {
+	if m := mtrHeader.FindStringSubmatch(line); m != nil {
+		p.columns = strings.Fields(m[1])
+		return
+	}
+
+	if m := mtrHop.FindStringSubmatch(line); m != nil {
+		ttl, _ := strconv.Atoi(m[1])
+		if hop := p.readHop(ttl, strings.Fields(m[2])); hop != nil {
+			p.hops = append(p.hops, hop)
+		}
+		return
+	}
+
+	if m := mtrMultipath.FindStringSubmatch(line); m != nil && len(p.hops) > 0 {
+		last := p.hops[len(p.hops)-1]
+		last["addresses"] = append(last["addresses"].([]string), strings.Trim(m[1], "()"))
+	}
+}
+
+// readHop reads the row of a hop: its host, optionally preceded by an AS
+// number, and the values of the columns
+func (p *mtrParser) readHop(ttl int, fields []string) map[string]any {
+	columns := p.columns
+	if columns == nil {
+		columns = mtrDefaultColumns
+	}
+	if len(fields) <= len(columns) {
+		return nil
+	}
+	hostFields, values := fields[:len(fields)-len(columns)], fields[len(fields)-len(columns):]
+
+	hop := map[string]any{
+		"ttl":       ttl,
+		"address":   "",
+		"addresses": []string{},
+	}
+	if len(hostFields) > 1 && strings.HasPrefix(hostFields[0], "AS") {
+		if hostFields[0] != "AS???" {
+			hop["asn"] = hostFields[0]
+		}
+		hostFields = hostFields[1:]
+	}
+
+	host, address := hostFields[0], ""
+	switch {
+	case host == "???":
+		host = ""
+	case len(hostFields) > 1 && strings.HasPrefix(hostFields[1], "("):
+		address = strings.Trim(hostFields[1], "()")
+	case isAddress(host):
+		host, address = "", host
+	}
+	if host != "" {
+		hop["host"] = host
+	}
+	if address != "" {
+		hop["address"] = address
+		hop["addresses"] = []string{address}
+	}
+
+	for i, column := range columns {
+		name, renamed := mtrColumns[column]
+		if !renamed {
+			name = strings.ToLower(column)
+		}
+		value, ok := number(strings.TrimSuffix(values[i], "%"))
+		if ok {
+			hop[name] = value
+		} else {
+			hop[name] = nil
+		}
+	}
+	return hop
+}
+
+func (p *mtrParser) Result() map[string]any {
+	if p.columns == nil && p.hops == nil {
+		return nil
+	}
+	hops := p.hops
+	if hops == nil {
+		hops = []map[string]any{}
+	}
+	return map[string]any{
+		"hops": hops,
+	}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lines of nexttrace output
+var (
+	// traceroute to 192.0.2.1, 30 hops max, 52 bytes payload
+	nexttraceHeader = regexp.MustCompile(`^traceroute to (\S+?)(?: \(([^)]+)\))?,`)
+	// 4   192.0.2.1   AS64500   [EXAMPLE-NET]   Frankfurt   r1.example.net
+	nexttraceHop = regexp.MustCompile(`^\s*(\d+)\s+(\S+)\s*(.*)$`)
+)
+
+// nexttraceParser reads the output of nexttrace, which prints the
+// round-trip times of a hop on the line below its responders
+type nexttraceParser struct {
+	target  string
+	address string
+	hops    []*hop
+}
+
+func (p *nexttraceParser) Line(line string) {
+	if m := nexttraceHeader.FindStringSubmatch(line); m != nil {
+		p.target = m[1]
+		switch {
+		case isAddress(m[1]):
+			p.address = m[1]
+		case isAddress(m[2]):
+			p.address = m[2]
+		}
+		return
+	}
+
+	// Round-trip times in whole milliseconds would pass for a hop
+	if m := nexttraceHop.FindStringSubmatch(line); m != nil && m[2] != "ms" {
+		ttl, _ := strconv.Atoi(m[1])
+		h := &hop{ttl: ttl}
+		p.hops = append(p.hops, h)
+		if m[2] != "*" {
+			h.addResponder(m[2], m[2])
+		}
+		readDetails(h, strings.Fields(m[3]))
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(p.hops) == 0 || len(fields) == 0 || strings.TrimLeft(line, " \t") == line {
+		return
+	}
+	h := p.hops[len(p.hops)-1]
+	switch {
+	case isAddress(fields[0]):
+		// Further responders of the hop
+		h.addResponder(fields[0], fields[0])
+	case strings.HasPrefix(fields[0], "["):
+		// MPLS labels
+	default:
+		readRTTs(h, fields)
+	}
+}
+
+// readDetails reads what follows the address of a hop: its AS number,
+// then registry, location and host names, and round-trip times on
+// versions printing them on the same line
+func readDetails(h *hop, fields []string) {
+	if len(fields) > 0 && (fields[0] == "*" || strings.HasPrefix(fields[0], "AS")) {
+		if fields[0] != "*" {
+			h.asn = fields[0]
+		}
+		fields = fields[1:]
+	}
+
+	var details []string
+	for i := 0; i < len(fields); i++ {
+		if _, ok := number(fields[i]); ok && i+1 < len(fields) && fields[i+1] == "ms" {
+			readRTTs(h, fields[i:])
+			break
+		}
+		details = append(details, fields[i])
+	}
+	h.details = strings.Join(details, " ")
+}
+
+// readRTTs reads round-trip times listed like 1.23 ms / * ms / 1.19 ms
+func readRTTs(h *hop, fields []string) {
+	for i := 0; i < len(fields); i++ {
+		switch field := fields[i]; {
+		case field == "*":
+			h.rtts = append(h.rtts, nil)
+		case i+1 < len(fields) && fields[i+1] == "ms":
+			if rtt, ok := number(field); ok {
+				h.rtts = append(h.rtts, rtt)
+			}
+		}
+	}
+}
+
+func (p *nexttraceParser) Result() map[string]any {
+	if !traced(p.target, p.hops) {
+		return nil
+	}
+	return map[string]any{
+		"target":  p.target,
+		"address": p.address,
+		"hops":    hopEvents(p.hops),
+	}
+}
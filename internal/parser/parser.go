@@ -0,0 +1,148 @@
+// Package parser extracts structured results from the text output of
+// common network tools, so API clients need not scrape it themselves.
+package parser
+
+import (
+	"math"
+	"net/netip"
+	"strconv"
+)
+
+// Parser reads the output of a command as it is streamed
+type Parser interface {
+	// Line takes a line of output, without its line ending
+	Line(line string)
+	// Result returns what the lines read so far describe, or nil when
+	// none of them was recognised as output of the tool
+	Result() map[string]any
+}
+
+var parsers = map[string]func() Parser{
+	"ping_iputils": func() Parser { return &pingParser{} },
+	"traceroute":   func() Parser { return &tracerouteParser{} },
+	"mtr_report":   func() Parser { return &mtrParser{} },
+	"nexttrace":    func() Parser { return &nexttraceParser{} },
+}
+
+// Lookup returns the constructor of a parser by name
+func Lookup(name string) (func() Parser, bool) {
+	newParser, exists := parsers[name]
+	return newParser, exists
+}
+
+// number parses a decimal number, reporting false for anything else
+func number(s string) (float64, bool) {
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+// isAddress reports whether s is an IP address
+func isAddress(s string) bool {
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// responder is a host answering at a hop, named unless the tool only
+// printed its address
+type responder struct {
+	host    string
+	address string
+}
+
+// hop collects the responders and round-trip times of a traceroute hop
+type hop struct {
+	ttl        int
+	responders []responder
+	rtts       []any // Milliseconds, nil for probes without answer
+	asn        string
+	flag       string // Such as !H, from the first probe flagged
+	details    string // What the tool printed about the hop besides its probes
+}
+
+// addResponder records a host answering at the hop, once
+func (h *hop) addResponder(host, address string) {
+	if host == address {
+		host = ""
+	}
+	for _, r := range h.responders {
+		if r.address == address && r.host == host {
+			return
+		}
+	}
+	h.responders = append(h.responders, responder{host: host, address: address})
+}
+
+// event describes the hop the way the native traceroute backend does, with
+// the loss of its probes and all of its responders
+func (h *hop) event() map[string]any {
+	answered := 0
+	for _, rtt := range h.rtts {
+		if rtt != nil {
+			answered++
+		}
+	}
+	loss := 100.0
+	if len(h.rtts) > 0 {
+		loss = float64(len(h.rtts)-answered) / float64(len(h.rtts)) * 100
+	}
+
+	addresses := []string{}
+	for _, r := range h.responders {
+		addresses = append(addresses, r.address)
+	}
+
+	rtts := h.rtts
+	if rtts == nil {
+		rtts = []any{}
+	}
+	event := map[string]any{
+		"ttl":       h.ttl,
+		"address":   "",
+		"addresses": addresses,
+		"rtts":      rtts,
+		"loss":      math.Round(loss*10) / 10,
+	}
+	if len(h.responders) > 0 {
+		event["address"] = h.responders[0].address
+		if h.responders[0].host != "" {
+			event["host"] = h.responders[0].host
+		}
+	}
+	if h.asn != "" {
+		event["asn"] = h.asn
+	}
+	if h.flag != "" {
+		event["flag"] = h.flag
+	}
+	if h.details != "" {
+		event["details"] = h.details
+	}
+	return event
+}
+
+// traced reports whether traceroute style output was recognised: its
+// header, or hops with the results of their probes, as lines merely
+// starting with a number would pass for hops
+func traced(target string, hops []*hop) bool {
+	if target != "" {
+		return true
+	}
+	for _, h := range hops {
+		if len(h.rtts) > 0 || h.flag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hopEvents describes a list of hops
+func hopEvents(hops []*hop) []map[string]any {
+	events := make([]map[string]any, len(hops))
+	for i, h := range hops {
+		events[i] = h.event()
+	}
+	return events
+}
@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected results in testdata")
+
+// TestParsers feeds the captures in testdata/<parser>/*.txt to the parser
+// of their directory and compares the result with the .json file next to
+// them, which holds null for output the parser must not recognise
+func TestParsers(t *testing.T) {
+	captures, err := filepath.Glob("testdata/*/*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) == 0 {
+		t.Fatal("no captures in testdata")
+	}
+
+	for _, capture := range captures {
+		name := filepath.Base(filepath.Dir(capture))
+		t.Run(name+"/"+strings.TrimSuffix(filepath.Base(capture), ".txt"), func(t *testing.T) {
+			newParser, exists := Lookup(name)
+			if !exists {
+				t.Fatalf("no parser named %s", name)
+			}
+			output, err := os.ReadFile(capture)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := newParser()
+			scanner := bufio.NewScanner(bytes.NewReader(output))
+			for scanner.Scan() {
+				p.Line(scanner.Text())
+			}
+			got, err := json.MarshalIndent(p.Result(), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(capture, ".txt") + ".json"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run the test with -update to create it", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("result differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
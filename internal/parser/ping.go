@@ -0,0 +1,204 @@
+package parser
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Lines of ping output. Besides iputils, the BusyBox and BSD pings print
+// close enough formats to be read the same way.
+var (
+	// PING example.com (192.0.2.1) 56(84) bytes of data.
+	// PING example.com(host.example.com (2001:db8::1)) 56 data bytes
+	pingHeader = regexp.MustCompile(`^PING (\S+?)\s*\((?:[^()]*\()?([^()\s]+)\)`)
+	// 64 bytes from host.example.com (192.0.2.1): icmp_seq=1 ttl=56 time=11.6 ms
+	pingReply = regexp.MustCompile(`^\d+ bytes from (\S+?)(?: \(([^)]*)\))?: (.*)$`)
+	// From 192.0.2.254 icmp_seq=1 Destination Host Unreachable
+	pingFailure = regexp.MustCompile(`^From (\S+?)(?: \(([^)]*)\))? icmp_seq=(\d+) (.+)$`)
+	// Request timeout for icmp_seq 0
+	pingTimeout = regexp.MustCompile(`^Request timeout for icmp_seq (\d+)`)
+	// 4 packets transmitted, 3 received, +1 errors, 25% packet loss, time 3004ms
+	pingSummary = regexp.MustCompile(`^(\d+) packets transmitted, (\d+) (?:packets )?received,.*?([\d.]+)% packet loss`)
+	// rtt min/avg/max/mdev = 11.552/11.644/11.738/0.068 ms
+	pingRTTs = regexp.MustCompile(`^(?:rtt|round-trip) min/avg/max(?:/(?:mdev|stddev))? = ([\d.]+)/([\d.]+)/([\d.]+)(?:/([\d.]+))? ms`)
+
+	pingSeq  = regexp.MustCompile(`\b(?:icmp_[rs]eq|seq)=(\d+)`)
+	pingTTL  = regexp.MustCompile(`\b(?:ttl|hlim)=(\d+)`)
+	pingTime = regexp.MustCompile(`\btime[=<]\s*([\d.]+) ?ms`)
+)
+
+// pingParser reads the output of ping
+type pingParser struct {
+	target   string
+	address  string
+	replies  []map[string]any
+	failures []map[string]any
+	rtts     []float64 // Of the replies, duplicates excluded
+	answered map[int]bool
+	first    int // Lowest and highest sequence numbers read, first is -1 until one is
+	last     int
+
+	summary map[string]any // Statistics printed by ping, nil until read
+}
+
+func (p *pingParser) Line(line string) {
+	if p.answered == nil {
+		p.answered, p.first = map[int]bool{}, -1
+	}
+
+	if m := pingHeader.FindStringSubmatch(line); m != nil {
+		p.target, p.address = m[1], m[2]
+		return
+	}
+
+	if m := pingReply.FindStringSubmatch(line); m != nil {
+		from := m[1]
+		if m[2] != "" {
+			from = m[2]
+		}
+		seq := -1
+		if s := pingSeq.FindStringSubmatch(m[3]); s != nil {
+			seq, _ = strconv.Atoi(s[1])
+			p.addSeq(seq)
+		}
+
+		t := pingTime.FindStringSubmatch(m[3])
+		if t == nil {
+			// BSD ping reports errors this way, with the packet dumped after
+			p.addFailure(seq, from, m[3])
+			return
+		}
+		rtt, _ := number(t[1])
+		reply := map[string]any{
+			"seq":  seq,
+			"from": from,
+			"rtt":  rtt,
+		}
+		if s := pingTTL.FindStringSubmatch(m[3]); s != nil {
+			reply["ttl"], _ = strconv.Atoi(s[1])
+		}
+		if seq >= 0 && p.answered[seq] {
+			reply["duplicate"] = true
+		} else {
+			p.answered[seq] = true
+			p.rtts = append(p.rtts, rtt)
+		}
+		p.replies = append(p.replies, reply)
+		return
+	}
+
+	if m := pingFailure.FindStringSubmatch(line); m != nil {
+		from := m[1]
+		if m[2] != "" {
+			from = m[2]
+		}
+		seq, _ := strconv.Atoi(m[3])
+		p.addSeq(seq)
+		p.addFailure(seq, from, m[4])
+		return
+	}
+
+	if m := pingTimeout.FindStringSubmatch(line); m != nil {
+		seq, _ := strconv.Atoi(m[1])
+		p.addSeq(seq)
+		p.addFailure(seq, "", "Request timeout")
+		return
+	}
+
+	if m := pingSummary.FindStringSubmatch(line); m != nil {
+		sent, _ := strconv.Atoi(m[1])
+		received, _ := strconv.Atoi(m[2])
+		loss, _ := number(m[3])
+		p.summary = map[string]any{
+			"sent":     sent,
+			"received": received,
+			"loss":     loss,
+		}
+		return
+	}
+
+	if m := pingRTTs.FindStringSubmatch(line); m != nil && p.summary != nil {
+		p.summary["min"], _ = number(m[1])
+		p.summary["avg"], _ = number(m[2])
+		p.summary["max"], _ = number(m[3])
+		if m[4] != "" {
+			p.summary["mdev"], _ = number(m[4])
+		}
+	}
+}
+
+// addSeq records the sequence number of a probe
+func (p *pingParser) addSeq(seq int) {
+	if p.first < 0 {
+		p.first, p.last = seq, seq
+	}
+	p.first, p.last = min(p.first, seq), max(p.last, seq)
+}
+
+// addFailure records a probe that got an error instead of a reply, with
+// seq -1 when ping did not tell which
+func (p *pingParser) addFailure(seq int, from, message string) {
+	p.failures = append(p.failures, map[string]any{
+		"seq":     seq,
+		"from":    from,
+		"message": message,
+	})
+}
+
+// Result returns the replies and the statistics ping printed, or when it
+// was stopped before printing them, statistics of the replies read, taking
+// the probes sent from the range of sequence numbers
+func (p *pingParser) Result() map[string]any {
+	if p.target == "" && p.replies == nil && p.failures == nil && p.summary == nil {
+		return nil
+	}
+	result := map[string]any{
+		"target":   p.target,
+		"address":  p.address,
+		"replies":  p.replies,
+		"failures": p.failures,
+		"min":      nil,
+		"avg":      nil,
+		"max":      nil,
+		"mdev":     nil,
+	}
+	if p.replies == nil {
+		result["replies"] = []map[string]any{}
+	}
+	if p.failures == nil {
+		result["failures"] = []map[string]any{}
+	}
+
+	if p.summary != nil {
+		for key, value := range p.summary {
+			result[key] = value
+		}
+		return result
+	}
+
+	sent, received := 0, len(p.rtts)
+	if p.answered != nil && p.first >= 0 {
+		sent = p.last - p.first + 1
+	}
+	result["sent"] = sent
+	result["received"] = received
+	result["loss"] = 0.0
+	if sent > 0 {
+		result["loss"] = math.Round(float64(max(sent-received, 0))/float64(sent)*1000) / 10
+	}
+	if received > 0 {
+		minimum, maximum, sum, squares := math.Inf(1), 0.0, 0.0, 0.0
+		for _, rtt := range p.rtts {
+			minimum, maximum = min(minimum, rtt), max(maximum, rtt)
+			sum += rtt
+			squares += rtt * rtt
+		}
+		avg := sum / float64(received)
+		result["min"] = minimum
+		result["avg"] = math.Round(avg*1000) / 1000
+		result["max"] = maximum
+		result["mdev"] = math.Round(math.Sqrt(max(squares/float64(received)-avg*avg, 0))*1000) / 1000
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lines of traceroute output, as printed by the Linux, BSD and BusyBox
+// traceroutes
+var (
+	// traceroute to example.com (192.0.2.1), 30 hops max, 60 byte packets
+	tracerouteHeader = regexp.MustCompile(`^traceroute6? to (\S+) \(([^)]+)\)`)
+	// 3  r1.example.net (192.0.2.1)  5.123 ms r2.example.net (192.0.2.2)  5.321 ms *
+	tracerouteHop = regexp.MustCompile(`^\s*(\d+)\s+(.*)$`)
+)
+
+// tracerouteParser reads the output of traceroute
+type tracerouteParser struct {
+	target  string
+	address string
+	hops    []*hop
+}
+
+func (p *tracerouteParser) Line(line string) {
+	if m := tracerouteHeader.FindStringSubmatch(line); m != nil {
+		p.target, p.address = m[1], m[2]
+		return
+	}
+
+	if m := tracerouteHop.FindStringSubmatch(line); m != nil {
+		ttl, _ := strconv.Atoi(m[1])
+		h := &hop{ttl: ttl}
+		p.hops = append(p.hops, h)
+		readProbes(h, strings.Fields(m[2]))
+		return
+	}
+
+	// BSD traceroute prints further responders of a hop on indented lines
+	// of their own
+	if len(p.hops) > 0 && strings.TrimLeft(line, " \t") != line {
+		readProbes(p.hops[len(p.hops)-1], strings.Fields(line))
+	}
+}
+
+// readProbes reads the responders, round-trip times and annotations
+// traceroute prints for the probes of a hop
+func readProbes(h *hop, fields []string) {
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		switch {
+		case field == "*":
+			h.rtts = append(h.rtts, nil)
+		case i+1 < len(fields) && fields[i+1] == "ms":
+			if rtt, ok := number(field); ok {
+				h.rtts = append(h.rtts, rtt)
+			}
+			i++
+		case strings.HasPrefix(field, "!"):
+			if h.flag == "" {
+				h.flag = field
+			}
+		case strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]"):
+			// AS numbers looked up with -A, [*] when unknown
+			if asn := strings.Trim(field, "[]"); strings.HasPrefix(asn, "AS") {
+				h.asn = asn
+			}
+		case strings.HasPrefix(field, "<"):
+			// ICMP extensions such as MPLS labels
+		default:
+			address := field
+			if i+1 < len(fields) && strings.HasPrefix(fields[i+1], "(") && strings.HasSuffix(fields[i+1], ")") {
+				address = strings.Trim(fields[i+1], "()")
+				i++
+			}
+			h.addResponder(field, address)
+		}
+	}
+}
+
+func (p *tracerouteParser) Result() map[string]any {
+	if !traced(p.target, p.hops) {
+		return nil
+	}
+	return map[string]any{
+		"target":  p.target,
+		"address": p.address,
+		"hops":    hopEvents(p.hops),
+	}
+}
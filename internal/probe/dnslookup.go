@@ -0,0 +1,88 @@
+package probe
+
+import (
+	"YALS/internal/dns"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// dnsSettings are the options of the dns_lookup backend
+type dnsSettings struct {
+	recordType string
+	resolver   string // "doh" for the configured DoH servers, or "system"
+	timeout    time.Duration
+}
+
+func parseDNS(opts Options) (s dnsSettings, err error) {
+	if s.recordType, err = opts.Enum("type", "A", slices.Sorted(maps.Keys(dns.RecordTypes))...); err != nil {
+		return s, err
+	}
+	if s.resolver, err = opts.Enum("resolver", "doh", "doh", "system"); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 5*time.Second, time.Second, 30*time.Second); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// runDNS looks up records of the target host, like dig. IP addresses are
+// looked up as PTR records of their reverse name.
+func runDNS(ctx context.Context, target Target, s dnsSettings, out Output) error {
+	if target.Host == "" {
+		return fmt.Errorf("a target is required")
+	}
+
+	name, recordType := target.Host, s.recordType
+	if ip := net.ParseIP(target.Host); ip != nil {
+		name, recordType = dns.ReverseName(ip), "PTR"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var (
+		answer *dns.Answer
+		err    error
+	)
+	if s.resolver == "system" {
+		answer, err = dns.QuerySystem(ctx, name, recordType)
+	} else {
+		answer, err = dns.GetResolver().Query(ctx, name, recordType)
+	}
+	if err != nil {
+		return fmt.Errorf("lookup of %s %s failed: %w", name, recordType, err)
+	}
+
+	out.Printf(";; Question: %s IN %s", name, recordType)
+	out.Printf(";; Server: %s, %s", answer.Server, formatRTT(answer.Duration))
+	out.Printf(";; Status: %s, %d answers", answer.Status, len(answer.Records))
+
+	records := make([]map[string]any, len(answer.Records))
+	if len(answer.Records) > 0 {
+		out.Printf("")
+	}
+	for i, record := range answer.Records {
+		out.Printf("%-32s %-7d IN %-6s %s", record.Name, record.TTL, record.Type, record.Data)
+		records[i] = map[string]any{
+			"name": record.Name,
+			"type": record.Type,
+			"ttl":  record.TTL,
+			"data": record.Data,
+		}
+	}
+
+	out.Event("dns", map[string]any{
+		"name":    name,
+		"type":    recordType,
+		"server":  answer.Server,
+		"status":  answer.Status,
+		"time":    roundMilliseconds(answer.Duration),
+		"answers": records,
+	})
+	return nil
+}
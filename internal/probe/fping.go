@@ -0,0 +1,287 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// fpingPayload is the payload size of fping probes, the size fping uses
+const fpingPayload = 56
+
+// fpingSettings are the options of the fping backend
+type fpingSettings struct {
+	count      int
+	interval   time.Duration // Between the probes of a target
+	timeout    time.Duration // Wait for replies after the last probe
+	maxTargets int
+}
+
+func parseFping(opts Options) (s fpingSettings, err error) {
+	if s.count, err = opts.Int("count", 3, 1, 20); err != nil {
+		return s, err
+	}
+	if s.interval, err = opts.Seconds("interval", time.Second, 200*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 30*time.Second); err != nil {
+		return s, err
+	}
+	if s.maxTargets, err = opts.Int("max_targets", 16, 1, 256); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// fpingResult is the outcome of pinging one of the targets
+type fpingResult struct {
+	host  string // As listed by the user, or an address of a prefix
+	ip    net.IP // Nil when it could not be resolved
+	stats rttStats
+	err   error
+}
+
+// runFping pings a list of targets concurrently, streaming a row per target
+// as text and as a target event once its probes are answered or timed out
+func runFping(ctx context.Context, target Target, s fpingSettings, out Output) error {
+	if target.Host == "" {
+		return fmt.Errorf("a target is required")
+	}
+
+	hosts, err := expandTargets(target.Host, s.maxTargets)
+	if err != nil {
+		return err
+	}
+
+	width := 0
+	for _, host := range hosts {
+		width = max(width, len(host))
+	}
+	out.Printf("FPING %s, %d probes of %d bytes each", plural(len(hosts), "target"), s.count, fpingPayload)
+
+	// Rows are printed here as results arrive, as outputs are not safe for
+	// concurrent use
+	results := make(chan fpingResult, len(hosts))
+	for _, host := range hosts {
+		go func() {
+			results <- pingHost(ctx, target, host, s)
+		}()
+	}
+
+	alive := 0
+	for range hosts {
+		r := <-results
+		if r.stats.received > 0 {
+			alive++
+		}
+		out.Printf("%s", formatFpingRow(r, width))
+		out.Event("target", fpingEvent(r))
+	}
+
+	out.Printf("")
+	out.Printf("%s, %d alive, %d unreachable", plural(len(hosts), "target"), alive, len(hosts)-alive)
+	out.Event("fping", map[string]any{
+		"targets":     len(hosts),
+		"alive":       alive,
+		"unreachable": len(hosts) - alive,
+	})
+	return ctx.Err()
+}
+
+// expandTargets splits a list of targets, replacing prefixes with their
+// host addresses, up to max targets
+func expandTargets(input string, limit int) ([]string, error) {
+	var hosts []string
+	listed := map[string]bool{}
+	add := func(host string) error {
+		if listed[host] {
+			return nil
+		}
+		if len(hosts) == limit {
+			return fmt.Errorf("at most %d targets can be probed at once", limit)
+		}
+		listed[host] = true
+		hosts = append(hosts, host)
+		return nil
+	}
+
+	for _, item := range SplitTargets(input) {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			if err := add(item); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		addrs, err := prefixHosts(prefix.Masked(), limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			if err := add(addr.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// prefixHosts returns the host addresses of a prefix. These exclude the
+// network and broadcast addresses of IPv4 prefixes and the subnet-router
+// anycast address of IPv6 ones, unless the prefix is a point-to-point /31 or
+// /127 or a single address.
+func prefixHosts(prefix netip.Prefix, limit int) ([]netip.Addr, error) {
+	bits := prefix.Addr().BitLen() - prefix.Bits()
+	if bits > 16 {
+		return nil, fmt.Errorf("prefix %s has more than the %d targets that can be probed at once", prefix, limit)
+	}
+
+	first, count := prefix.Addr(), 1<<bits
+	if bits >= 2 {
+		first, count = first.Next(), count-1
+		if prefix.Addr().Is4() {
+			count--
+		}
+	}
+	if count > limit {
+		return nil, fmt.Errorf("prefix %s has %d addresses, more than the %d targets that can be probed at once", prefix, count, limit)
+	}
+
+	addrs := make([]netip.Addr, 0, count)
+	for addr := first; len(addrs) < count; addr = addr.Next() {
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// pingHost resolves a host and sends it echo requests, returning the
+// statistics of the replies
+func pingHost(ctx context.Context, target Target, host string, s fpingSettings) fpingResult {
+	r := fpingResult{host: host}
+
+	r.ip, r.err = target.Resolve(ctx, host)
+	if r.err != nil {
+		return r
+	}
+
+	conn, err := listenICMP(r.ip.To4() == nil, false, nil)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	defer conn.Close()
+
+	messages, stopReceiving := receiveICMP(conn)
+	defer close(stopReceiving)
+
+	var (
+		payload  = make([]byte, fpingPayload)
+		sentAt   = map[int]time.Time{}
+		answered = map[int]bool{}
+		seq      = 0
+		finish   <-chan time.Time // Set once the last request is sent
+	)
+	send := time.NewTimer(0)
+	defer send.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return r
+
+		case <-send.C:
+			seq++
+			sentAt[seq] = time.Now()
+			if err := conn.sendEcho(r.ip, seq, payload); err != nil {
+				r.err = err
+				return r
+			}
+			r.stats.sent++
+			if seq < s.count {
+				send.Reset(s.interval)
+			} else {
+				finish = time.After(s.timeout)
+			}
+
+		case msg, ok := <-messages:
+			if !ok {
+				r.err = fmt.Errorf("receiving replies failed")
+				return r
+			}
+			sent, exists := sentAt[msg.seq]
+			if msg.kind != echoReply || !exists || answered[msg.seq] {
+				continue
+			}
+			answered[msg.seq] = true
+			r.stats.add(msg.at.Sub(sent))
+			if len(answered) == s.count {
+				return r
+			}
+
+		case <-finish:
+			return r
+		}
+	}
+}
+
+// formatFpingRow formats the result of a target the way fping summarizes
+// targets, with the host column padded to width
+func formatFpingRow(r fpingResult, width int) string {
+	line := fmt.Sprintf("%-*s : ", width, r.host)
+	if r.err != nil {
+		return line + r.err.Error()
+	}
+
+	status := "unreachable"
+	if r.stats.received > 0 {
+		status = "alive"
+	}
+	line += fmt.Sprintf("%-11s xmt/rcv/%%loss = %d/%d/%.0f%%", status, r.stats.sent, r.stats.received, r.stats.loss())
+	if r.stats.received > 0 {
+		line += fmt.Sprintf(", min/avg/max = %.3f/%.3f/%.3f ms",
+			milliseconds(r.stats.min), milliseconds(r.stats.avg()), milliseconds(r.stats.max))
+	}
+	if r.host != r.ip.String() {
+		line += fmt.Sprintf(" (%s)", r.ip)
+	}
+	return line
+}
+
+// fpingEvent describes the result of a target, with round-trip times in
+// milliseconds, null when no probe was answered
+func fpingEvent(r fpingResult) map[string]any {
+	event := map[string]any{
+		"target":   r.host,
+		"address":  "",
+		"alive":    r.stats.received > 0,
+		"sent":     r.stats.sent,
+		"received": r.stats.received,
+		"loss":     roundPercent(r.stats.loss()),
+		"min":      nil,
+		"avg":      nil,
+		"max":      nil,
+	}
+	if r.ip != nil {
+		event["address"] = r.ip.String()
+	}
+	if r.stats.received > 0 {
+		event["min"] = roundMilliseconds(r.stats.min)
+		event["avg"] = roundMilliseconds(r.stats.avg())
+		event["max"] = roundMilliseconds(r.stats.max)
+	}
+	if r.err != nil {
+		event["failure"] = r.err.Error()
+	}
+	return event
+}
+
+// plural formats a count of things, adding an s to the noun unless one
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,38 @@
+package probe
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPrefixHosts(t *testing.T) {
+	tests := []struct {
+		prefix      string
+		first, last string
+		count       int
+	}{
+		{"192.0.2.0/24", "192.0.2.1", "192.0.2.254", 254},
+		{"192.0.2.0/30", "192.0.2.1", "192.0.2.2", 2},
+		{"192.0.2.0/31", "192.0.2.0", "192.0.2.1", 2},
+		{"192.0.2.7/32", "192.0.2.7", "192.0.2.7", 1},
+		{"2001:db8::/120", "2001:db8::1", "2001:db8::ff", 255},
+		{"2001:db8::/126", "2001:db8::1", "2001:db8::3", 3},
+		{"2001:db8::/127", "2001:db8::", "2001:db8::1", 2},
+		{"2001:db8::1/128", "2001:db8::1", "2001:db8::1", 1},
+	}
+	for _, test := range tests {
+		addrs, err := prefixHosts(netip.MustParsePrefix(test.prefix), 256)
+		if err != nil {
+			t.Errorf("%s: %v", test.prefix, err)
+			continue
+		}
+		if len(addrs) != test.count || addrs[0].String() != test.first || addrs[len(addrs)-1].String() != test.last {
+			t.Errorf("%s: got %d addresses from %s to %s, want %d from %s to %s", test.prefix,
+				len(addrs), addrs[0], addrs[len(addrs)-1], test.count, test.first, test.last)
+		}
+	}
+
+	if _, err := prefixHosts(netip.MustParsePrefix("192.0.2.0/23"), 256); err == nil {
+		t.Error("a /23 was accepted with a limit of 256 targets")
+	}
+}
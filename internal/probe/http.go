@@ -0,0 +1,201 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxHTTPBody is how much of a response body is read to time its transfer
+const maxHTTPBody = 10 << 20
+
+// httpSettings are the options of the http_probe backend
+type httpSettings struct {
+	method       string
+	timeout      time.Duration // Per request
+	maxRedirects int
+	headers      []string // Response headers shown
+}
+
+func parseHTTP(opts Options) (s httpSettings, err error) {
+	if s.method, err = opts.Enum("method", "GET", "GET", "HEAD"); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 10*time.Second, time.Second, 60*time.Second); err != nil {
+		return s, err
+	}
+	if s.maxRedirects, err = opts.Int("max_redirects", 5, 0, 10); err != nil {
+		return s, err
+	}
+	s.headers = opts.List("headers", []string{"Server", "Content-Type", "Content-Length", "Location", "Cache-Control"})
+	return s, nil
+}
+
+// httpTiming holds the address a request was sent to and how long its
+// phases took, zero for phases that did not happen. The first byte and
+// total times are counted from the start of the request.
+type httpTiming struct {
+	address   string
+	dns       time.Duration
+	connect   time.Duration
+	tls       time.Duration
+	firstByte time.Duration
+	total     time.Duration
+}
+
+// runHTTP requests the target URL from this node and reports the timing of
+// each phase, the status and the selected headers, following redirects
+// through the same checks as the target
+func runHTTP(ctx context.Context, target Target, s httpSettings, out Output) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("an http or https URL is required")
+	}
+
+	method := s.method
+	for redirects := 0; ; redirects++ {
+		out.Printf("%s %s", method, u)
+		resp, timing, err := httpRequest(ctx, target, method, u, s.timeout)
+		if err != nil {
+			return err
+		}
+
+		out.Printf("Connected to %s", timing.address)
+		out.Printf("%s %s", resp.Proto, resp.Status)
+		headers := map[string]any{}
+		for _, name := range s.headers {
+			if value := resp.Header.Get(name); value != "" {
+				out.Printf("%s: %s", http.CanonicalHeaderKey(name), value)
+				headers[http.CanonicalHeaderKey(name)] = value
+			}
+		}
+		out.Printf("Timing: dns %s, connect %s, tls %s, first byte %s, total %s",
+			formatPhase(timing.dns), formatPhase(timing.connect), formatPhase(timing.tls),
+			formatPhase(timing.firstByte), formatPhase(timing.total))
+		out.Event("http", map[string]any{
+			"url":        u.String(),
+			"address":    timing.address,
+			"status":     resp.StatusCode,
+			"proto":      resp.Proto,
+			"headers":    headers,
+			"dns":        roundMilliseconds(timing.dns),
+			"connect":    roundMilliseconds(timing.connect),
+			"tls":        roundMilliseconds(timing.tls),
+			"first_byte": roundMilliseconds(timing.firstByte),
+			"total":      roundMilliseconds(timing.total),
+		})
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode > 399 || location == "" {
+			return nil
+		}
+		next, err := u.Parse(location)
+		if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+			return fmt.Errorf("invalid redirect location: %s", location)
+		}
+		if redirects == s.maxRedirects {
+			out.Printf("Not following more than %d redirects", s.maxRedirects)
+			return nil
+		}
+
+		out.Printf("")
+		out.Printf("Redirected to %s", next)
+		u = next
+		if resp.StatusCode != http.StatusTemporaryRedirect && resp.StatusCode != http.StatusPermanentRedirect && method != http.MethodHead {
+			method = http.MethodGet
+		}
+	}
+}
+
+// httpRequest sends a single request, resolving and connecting to the host
+// of u itself to time each phase. The body is read and discarded.
+func httpRequest(ctx context.Context, target Target, method string, u *url.URL, timeout time.Duration) (*http.Response, httpTiming, error) {
+	var timing httpTiming
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	ip, err := target.Resolve(ctx, u.Hostname())
+	if err != nil {
+		return nil, timing, err
+	}
+	if net.ParseIP(u.Hostname()) == nil {
+		timing.dns = time.Since(start)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	address := net.JoinHostPort(ip.String(), port)
+	timing.address = address
+
+	var connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { timing.connect = time.Since(connectStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { timing.tls = time.Since(tlsStart) },
+		GotFirstResponseByte: func() { timing.firstByte = time.Since(start) },
+	}
+
+	// The connection goes to the resolved address, whatever the URL's host
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+		DisableKeepAlives: true,
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, u.String(), nil)
+	if err != nil {
+		return nil, timing, err
+	}
+	req.Header.Set("User-Agent", "YALS http_probe")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, timing, fmt.Errorf("request to %s failed: %w", address, err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(io.Discard, io.LimitReader(resp.Body, maxHTTPBody)); err != nil {
+		return nil, timing, fmt.Errorf("reading response from %s failed: %w", address, err)
+	}
+	timing.total = time.Since(start)
+	return resp, timing, nil
+}
+
+// formatPhase formats the duration of a request phase, or a dash for phases
+// that did not happen
+func formatPhase(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(milliseconds(d), 'f', 1, 64) + " ms"
+}
@@ -0,0 +1,75 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hop int
+		fmt.Sscanf(r.URL.Path, "/hop/%d", &hop)
+		w.Header().Set("Server", "test")
+		http.Redirect(w, r, fmt.Sprintf("/hop/%d", hop+1), http.StatusFound)
+	}))
+	defer server.Close()
+
+	target := Target{
+		URL: server.URL + "/hop/0",
+		Resolve: func(ctx context.Context, host string) (net.IP, error) {
+			return net.ParseIP(host), nil
+		},
+	}
+	s := httpSettings{method: "GET", timeout: 5 * time.Second, maxRedirects: 2, headers: []string{"Server", "Location"}}
+	out := &recorder{}
+	if err := runHTTP(context.Background(), target, s, out); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	hops := out.find("http")
+	if len(hops) != 3 {
+		t.Fatalf("got %d http events, want the request and 2 redirects", len(hops))
+	}
+	for i, hop := range hops {
+		if want := fmt.Sprintf("%s/hop/%d", server.URL, i); hop["url"] != want {
+			t.Errorf("hop %d: got url %v, want %s", i, hop["url"], want)
+		}
+		if hop["status"] != http.StatusFound {
+			t.Errorf("hop %d: got status %v, want 302", i, hop["status"])
+		}
+		if hop["address"] != server.Listener.Addr().String() {
+			t.Errorf("hop %d: got address %v, want %s", i, hop["address"], server.Listener.Addr())
+		}
+		headers := hop["headers"].(map[string]any)
+		if headers["Server"] != "test" || headers["Location"] != fmt.Sprintf("/hop/%d", i+1) {
+			t.Errorf("hop %d: got headers %v", i, headers)
+		}
+
+		timing := map[string]float64{}
+		for _, key := range []string{"dns", "connect", "tls", "first_byte", "total"} {
+			value, ok := hop[key].(float64)
+			if !ok {
+				t.Fatalf("hop %d: %s is %v, want milliseconds", i, key, hop[key])
+			}
+			timing[key] = value
+		}
+		// The URL holds an address and is plain HTTP
+		if timing["dns"] != 0 || timing["tls"] != 0 {
+			t.Errorf("hop %d: got dns %v and tls %v, want 0", i, timing["dns"], timing["tls"])
+		}
+		if timing["first_byte"] <= 0 || timing["total"] < timing["first_byte"] {
+			t.Errorf("hop %d: got first byte %v and total %v", i, timing["first_byte"], timing["total"])
+		}
+	}
+
+	if !slices.Contains(out.lines, "Not following more than 2 redirects") {
+		t.Errorf("redirect limit not reported:\n%s", strings.Join(out.lines, "\n"))
+	}
+}
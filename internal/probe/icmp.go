@@ -0,0 +1,348 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// IANA protocol numbers of ICMP
+const (
+	protocolICMP   = 1
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+// icmpConn is an ICMP socket for sending echo requests and receiving
+// replies. Unprivileged datagram sockets are used where the system allows
+// them; they only receive replies to their own requests, and no ICMP
+// errors. Raw sockets receive all ICMP messages, which are filtered by the
+// echo ID.
+type icmpConn struct {
+	conn       net.PacketConn
+	p4         *ipv4.PacketConn
+	p6         *ipv6.PacketConn
+	ipv6       bool
+	privileged bool // Raw socket
+	id         int  // Echo ID, rewritten by the kernel on datagram sockets
+}
+
+// icmpKind is the type of an ICMP message, independent of the IP version
+type icmpKind int
+
+const (
+	echoReply icmpKind = iota
+	timeExceeded
+	unreachable
+	packetTooBig
+)
+
+// icmpMessage is an ICMP message received in response to a probe
+type icmpMessage struct {
+	from        net.IP
+	ttl         int // TTL or hop limit of the reply, -1 when unknown
+	size        int // Length of the ICMP message
+	at          time.Time
+	kind        icmpKind
+	code        int
+	description string
+	id          int
+	seq         int
+	mtu         int    // Next-hop MTU of packet too big errors, zero when unknown
+	original    []byte // For errors, the start of the datagram that caused it
+}
+
+// listenICMP opens an ICMP socket for the IP version of the target. Only a
+// raw socket is tried when raw is set, as probes other than echo requests
+// need one to receive ICMP errors. Raw sockets are adjusted by control
+// before they are bound, when set.
+func listenICMP(v6, raw bool, control func(network, address string, c syscall.RawConn) error) (*icmpConn, error) {
+	datagramNetwork, rawNetwork := "udp4", "ip4:icmp"
+	address := "0.0.0.0"
+	if v6 {
+		datagramNetwork, rawNetwork = "udp6", "ip6:ipv6-icmp"
+		address = "::"
+	}
+
+	c := &icmpConn{ipv6: v6, id: rand.IntN(0xffff) + 1}
+
+	var conn *icmp.PacketConn
+	err := errors.New("raw socket required")
+	if !raw {
+		if conn, err = icmp.ListenPacket(datagramNetwork, address); err == nil {
+			c.conn = conn
+			c.p4, c.p6 = conn.IPv4PacketConn(), conn.IPv6PacketConn()
+		}
+	}
+	if err != nil {
+		lc := net.ListenConfig{Control: control}
+		rawConn, rawErr := lc.ListenPacket(context.Background(), rawNetwork, address)
+		if rawErr != nil {
+			if raw {
+				return nil, fmt.Errorf("cannot open a raw ICMP socket, which needs root or CAP_NET_RAW: %v", rawErr)
+			}
+			return nil, fmt.Errorf("cannot open an ICMP socket: %v; raw socket: %v", err, rawErr)
+		}
+		c.conn = rawConn
+		c.p4, c.p6 = ipv4.NewPacketConn(rawConn), ipv6.NewPacketConn(rawConn)
+		c.privileged = true
+	}
+
+	// The TTL of replies is reported where the platform supports it
+	if v6 {
+		p := c.p6
+		p.SetControlMessage(ipv6.FlagHopLimit, true)
+		if c.privileged {
+			var filter ipv6.ICMPFilter
+			filter.SetAll(true)
+			for _, typ := range []ipv6.ICMPType{ipv6.ICMPTypeEchoReply, ipv6.ICMPTypeTimeExceeded, ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypePacketTooBig} {
+				filter.Accept(typ)
+			}
+			p.SetICMPFilter(&filter)
+		}
+	} else {
+		c.p4.SetControlMessage(ipv4.FlagTTL, true)
+	}
+
+	return c, nil
+}
+
+func (c *icmpConn) Close() error {
+	return c.conn.Close()
+}
+
+// setTTL sets the TTL or hop limit of outgoing requests
+func (c *icmpConn) setTTL(ttl int) error {
+	if c.ipv6 {
+		return c.p6.SetHopLimit(ttl)
+	}
+	return c.p4.SetTTL(ttl)
+}
+
+// sendEcho sends an echo request with the given sequence number and payload
+func (c *icmpConn) sendEcho(dst net.IP, seq int, payload []byte) error {
+	var typ icmp.Type = ipv4.ICMPTypeEcho
+	if c.ipv6 {
+		typ = ipv6.ICMPTypeEchoRequest
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: c.id, Seq: seq & 0xffff, Data: payload},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	var addr net.Addr = &net.IPAddr{IP: dst}
+	if !c.privileged {
+		addr = &net.UDPAddr{IP: dst}
+	}
+	_, err = c.conn.WriteTo(b, addr)
+	return err
+}
+
+// read waits for the next ICMP message until the deadline. Messages that
+// cannot be related to this socket's probes are skipped.
+func (c *icmpConn) read(deadline time.Time) (*icmpMessage, error) {
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65536)
+	for {
+		var (
+			n   int
+			src net.Addr
+			ttl = -1
+			err error
+		)
+		if c.ipv6 {
+			var cm *ipv6.ControlMessage
+			n, cm, src, err = c.p6.ReadFrom(buf)
+			if cm != nil {
+				ttl = cm.HopLimit
+			}
+		} else {
+			var cm *ipv4.ControlMessage
+			n, cm, src, err = c.p4.ReadFrom(buf)
+			if cm != nil {
+				ttl = cm.TTL
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		msg, ok := c.parse(buf[:n])
+		if !ok {
+			continue
+		}
+		msg.from = addrIP(src)
+		msg.ttl = ttl
+		msg.at = time.Now()
+		return msg, nil
+	}
+}
+
+// parse decodes an ICMP message, reporting false for messages that are not
+// replies or errors
+func (c *icmpConn) parse(b []byte) (*icmpMessage, bool) {
+	protocol := protocolICMP
+	if c.ipv6 {
+		protocol = protocolICMPv6
+	}
+	m, err := icmp.ParseMessage(protocol, b)
+	if err != nil {
+		return nil, false
+	}
+
+	msg := &icmpMessage{size: len(b), code: m.Code}
+	switch body := m.Body.(type) {
+	case *icmp.Echo:
+		if m.Type != ipv4.ICMPTypeEchoReply && m.Type != ipv6.ICMPTypeEchoReply {
+			return nil, false
+		}
+		// Datagram sockets only receive their own replies, with the ID
+		// replaced by the kernel
+		if c.privileged && body.ID != c.id {
+			return nil, false
+		}
+		msg.kind = echoReply
+		msg.description = "echo reply"
+		msg.id = body.ID
+		msg.seq = body.Seq
+		return msg, true
+	case *icmp.TimeExceeded:
+		msg.kind = timeExceeded
+		msg.description = "Time to live exceeded"
+		msg.original = body.Data
+	case *icmp.DstUnreach:
+		msg.kind = unreachable
+		msg.description = unreachableReason(c.ipv6, m.Code)
+		msg.original = body.Data
+		// Routers report their next-hop MTU in the unused header field
+		if !c.ipv6 && m.Code == 4 {
+			msg.mtu = int(binary.BigEndian.Uint16(b[6:8]))
+			msg.description = fmt.Sprintf("Frag needed and DF set (mtu = %d)", msg.mtu)
+		}
+	case *icmp.PacketTooBig:
+		msg.kind = packetTooBig
+		msg.mtu = body.MTU
+		msg.description = fmt.Sprintf("Packet too big, MTU %d", body.MTU)
+		msg.original = body.Data
+	default:
+		return nil, false
+	}
+	return msg, true
+}
+
+// originalEcho returns the ID and sequence number of the echo request
+// quoted in an ICMP error
+func originalEcho(original []byte, v6 bool) (id, seq int, ok bool) {
+	payload, protocol, ok := originalPayload(original, v6)
+	if !ok || len(payload) < 8 {
+		return 0, 0, false
+	}
+	if (v6 && protocol != protocolICMPv6) || (!v6 && protocol != protocolICMP) {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint16(payload[4:6])), int(binary.BigEndian.Uint16(payload[6:8])), true
+}
+
+// originalUDP returns the ports of the UDP datagram quoted in an ICMP error
+func originalUDP(original []byte, v6 bool) (srcPort, dstPort int, ok bool) {
+	payload, protocol, ok := originalPayload(original, v6)
+	if !ok || len(payload) < 4 || protocol != protocolUDP {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint16(payload[0:2])), int(binary.BigEndian.Uint16(payload[2:4])), true
+}
+
+// originalPayload skips the IP header of the datagram quoted in an ICMP
+// error and returns its payload and protocol
+func originalPayload(original []byte, v6 bool) ([]byte, int, bool) {
+	if v6 {
+		if len(original) < ipv6.HeaderLen {
+			return nil, 0, false
+		}
+		return original[ipv6.HeaderLen:], int(original[6]), true
+	}
+	if len(original) < ipv4.HeaderLen {
+		return nil, 0, false
+	}
+	headerLen := int(original[0]&0x0f) << 2
+	if headerLen < ipv4.HeaderLen || len(original) < headerLen {
+		return nil, 0, false
+	}
+	return original[headerLen:], int(original[9]), true
+}
+
+// isPortUnreachable reports whether an ICMP error is the port unreachable
+// sent by a destination that received a UDP probe
+func isPortUnreachable(msg *icmpMessage, v6 bool) bool {
+	if msg.kind != unreachable {
+		return false
+	}
+	if v6 {
+		return msg.code == 4
+	}
+	return msg.code == 3
+}
+
+// unreachableReason describes the code of a destination unreachable error
+func unreachableReason(v6 bool, code int) string {
+	if v6 {
+		switch code {
+		case 0:
+			return "Destination unreachable: No route"
+		case 1:
+			return "Destination unreachable: Administratively prohibited"
+		case 3:
+			return "Destination unreachable: Address unreachable"
+		case 4:
+			return "Destination unreachable: Port unreachable"
+		}
+	} else {
+		switch code {
+		case 0:
+			return "Destination Net Unreachable"
+		case 1:
+			return "Destination Host Unreachable"
+		case 3:
+			return "Destination Port Unreachable"
+		case 4:
+			return "Frag needed and DF set"
+		case 9, 10, 13:
+			return "Destination Administratively Prohibited"
+		}
+	}
+	return fmt.Sprintf("Destination unreachable, code %d", code)
+}
+
+// isTimeout reports whether a read failed because its deadline passed
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// addrIP returns the IP of a socket address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,163 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+)
+
+// mtrSettings are the options of the native_mtr backend
+type mtrSettings struct {
+	mode     string // "udp" or "icmp"
+	maxHops  int
+	cycles   int
+	interval time.Duration // Between the starts of cycles
+	timeout  time.Duration // Wait for the replies of a cycle
+}
+
+func parseMTR(opts Options) (s mtrSettings, err error) {
+	if s.mode, err = opts.Enum("mode", "icmp", "udp", "icmp"); err != nil {
+		return s, err
+	}
+	if s.maxHops, err = opts.Int("max_hops", 30, 1, 255); err != nil {
+		return s, err
+	}
+	if s.cycles, err = opts.Int("cycles", 10, 1, 100); err != nil {
+		return s, err
+	}
+	if s.interval, err = opts.Seconds("interval", time.Second, 500*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// mtrHop accumulates the replies of a hop over the cycles
+type mtrHop struct {
+	address net.IP // Latest address that answered
+	stats   rttStats
+}
+
+// runMTR probes every hop to the target once per cycle, sending a snapshot
+// event of the statistics after each cycle and printing them as a report
+// at the end, like mtr --report
+func runMTR(ctx context.Context, target Target, s mtrSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+
+	t, err := newTracer(target, s.mode, nil)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	messages, stopReceiving := receiveICMP(t.conn)
+	defer close(stopReceiving)
+
+	out.Printf("Start: %s", time.Now().Format("2006-01-02T15:04:05-0700"))
+
+	hops := make([]mtrHop, s.maxHops)
+	limit := s.maxHops // Hops up to the target, once it answered
+	for cycle := 1; cycle <= s.cycles; cycle++ {
+		started := time.Now()
+
+		ttls := make([]int, limit)
+		for i := range ttls {
+			ttls[i] = i + 1
+		}
+		probes, err := t.probe(ctx, ttls, s.timeout, messages)
+		if err != nil {
+			if ctx.Err() != nil {
+				mtrReport(out, hops[:limit])
+			}
+			return err
+		}
+
+		for i, p := range probes {
+			hop := &hops[i]
+			hop.stats.sent++
+			if p.answered {
+				hop.stats.add(p.rtt)
+				hop.address = p.from
+			}
+			if (p.reached || p.flag != "") && i+1 < limit {
+				limit = i + 1
+			}
+		}
+		out.Event("snapshot", map[string]any{
+			"cycle":  cycle,
+			"cycles": s.cycles,
+			"hops":   mtrSnapshot(reportedHops(hops[:limit])),
+		})
+
+		if cycle < s.cycles {
+			select {
+			case <-ctx.Done():
+				mtrReport(out, hops[:limit])
+				return ctx.Err()
+			case <-time.After(s.interval - time.Since(started)):
+			}
+		}
+	}
+
+	mtrReport(out, hops[:limit])
+	return nil
+}
+
+// reportedHops leaves out the hops past the last one that answered, which
+// are all that remain of a target that never did
+func reportedHops(hops []mtrHop) []mtrHop {
+	last := len(hops)
+	for last > 1 && hops[last-1].stats.received == 0 {
+		last--
+	}
+	return hops[:last]
+}
+
+// mtrSnapshot describes the statistics of the hops for clients drawing a
+// table, with times in milliseconds
+func mtrSnapshot(hops []mtrHop) []map[string]any {
+	snapshot := make([]map[string]any, len(hops))
+	for i, hop := range hops {
+		address := ""
+		if hop.address != nil {
+			address = hop.address.String()
+		}
+		snapshot[i] = map[string]any{
+			"ttl":      i + 1,
+			"address":  address,
+			"loss":     roundPercent(hop.stats.loss()),
+			"sent":     hop.stats.sent,
+			"received": hop.stats.received,
+			"last":     roundMilliseconds(hop.stats.last),
+			"avg":      roundMilliseconds(hop.stats.avg()),
+			"best":     roundMilliseconds(hop.stats.min),
+			"worst":    roundMilliseconds(hop.stats.max),
+			"stdev":    roundMilliseconds(hop.stats.stdev()),
+		}
+	}
+	return snapshot
+}
+
+// mtrReport prints the statistics of the hops in the format of mtr --report
+func mtrReport(out Output, hops []mtrHop) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	out.Printf("HOST: %-28s Loss%%   Snt   Last   Avg  Best  Wrst StDev", host)
+
+	for i, hop := range reportedHops(hops) {
+		address := "???"
+		if hop.address != nil {
+			address = hop.address.String()
+		}
+		s := &hop.stats
+		out.Printf("%3d.|-- %-25s %5.1f%% %5d %6.1f %5.1f %5.1f %5.1f %5.1f", i+1, address, s.loss(), s.sent,
+			milliseconds(s.last), milliseconds(s.avg()), milliseconds(s.min), milliseconds(s.max), milliseconds(s.stdev()))
+	}
+}
@@ -0,0 +1,323 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	ntpPacketLen  = 48
+	ntpVersion    = 4
+	ntpModeClient = 3
+	ntpModeServer = 4
+)
+
+// ntpEpochOffset is the number of seconds from the NTP epoch, 1900, to the
+// Unix epoch
+const ntpEpochOffset = 2208988800
+
+// ntpSettings are the options of the ntp_query backend
+type ntpSettings struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration // Per request
+	port     int           // Used when the target has no port
+}
+
+func parseNTP(opts Options) (s ntpSettings, err error) {
+	if s.count, err = opts.Int("count", 4, 1, 20); err != nil {
+		return s, err
+	}
+	// Servers rate limit clients polling more often
+	if s.interval, err = opts.Seconds("interval", time.Second, 500*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	if s.port, err = opts.Int("port", 123, 1, 65535); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// ntpSample is a server reply with the clock offset and round-trip delay
+// it yields
+type ntpSample struct {
+	leap           int
+	version        int
+	stratum        int
+	precision      int // Log2 seconds
+	rootDelay      time.Duration
+	rootDispersion time.Duration
+	refID          string
+	offset         time.Duration
+	delay          time.Duration
+}
+
+// runNTP sends SNTP requests to the target, streaming the offset and delay
+// of each reply as text and as a sample event, followed by statistics
+func runNTP(ctx context.Context, target Target, s ntpSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+
+	port := target.Port
+	if port == 0 {
+		port = s.port
+	}
+	address := net.JoinHostPort(target.IP.String(), strconv.Itoa(port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return fmt.Errorf("cannot open a UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	out.Printf("NTP %s (%s) port %d", target.Host, target.IP, port)
+
+	var (
+		delays  rttStats
+		offsets []time.Duration
+		start   = time.Now()
+	)
+	for seq := 1; seq <= s.count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+				ntpSummary(out, target, &delays, offsets, time.Since(start))
+				return ctx.Err()
+			case <-time.After(s.interval):
+			}
+		}
+
+		sample, err := queryNTP(conn, s.timeout)
+		if ctx.Err() != nil {
+			ntpSummary(out, target, &delays, offsets, time.Since(start))
+			return ctx.Err()
+		}
+		delays.sent++
+
+		var kiss kissOfDeath
+		switch {
+		case errors.As(err, &kiss):
+			out.Printf("From %s: ntp_seq=%d Kiss-o'-Death %s", address, seq, kiss.code)
+			// Servers sending these codes ask clients to stop
+			if kiss.code == "DENY" || kiss.code == "RSTR" {
+				ntpSummary(out, target, &delays, offsets, time.Since(start))
+				return fmt.Errorf("access denied by %s", address)
+			}
+			continue
+		case isTimeout(err):
+			out.Printf("Request timeout for ntp_seq=%d", seq)
+			continue
+		case err != nil:
+			out.Printf("From %s: ntp_seq=%d %s", address, seq, connectFailure(err))
+			continue
+		}
+
+		delays.add(sample.delay)
+		offsets = append(offsets, sample.offset)
+		out.Printf("From %s: ntp_seq=%d stratum=%d offset=%s delay=%s refid=%s leap=%s",
+			address, seq, sample.stratum, formatOffset(sample.offset), formatRTT(sample.delay), sample.refID, leapIndicator(sample.leap))
+		out.Event("sample", map[string]any{
+			"seq":             seq,
+			"address":         address,
+			"stratum":         sample.stratum,
+			"version":         sample.version,
+			"offset":          roundMilliseconds(sample.offset),
+			"delay":           roundMilliseconds(sample.delay),
+			"ref_id":          sample.refID,
+			"leap":            leapIndicator(sample.leap),
+			"precision":       sample.precision,
+			"root_delay":      roundMilliseconds(sample.rootDelay),
+			"root_dispersion": roundMilliseconds(sample.rootDispersion),
+		})
+	}
+
+	ntpSummary(out, target, &delays, offsets, time.Since(start))
+	return nil
+}
+
+// kissOfDeath is a reply of stratum 0, which carries a code telling the
+// client why it is not served
+type kissOfDeath struct {
+	code string
+}
+
+func (k kissOfDeath) Error() string {
+	return "Kiss-o'-Death " + k.code
+}
+
+// queryNTP sends a client request and waits for the matching reply. The
+// transmit timestamp of requests is random, as suggested for SNTP clients,
+// which leaves no clue to the local clock and lets replies be matched by
+// the origin timestamp the server copies it to.
+func queryNTP(conn net.Conn, timeout time.Duration) (ntpSample, error) {
+	request := make([]byte, ntpPacketLen)
+	request[0] = ntpVersion<<3 | ntpModeClient
+	cookie := rand.Uint64()
+	binary.BigEndian.PutUint64(request[40:48], cookie)
+
+	deadline := time.Now().Add(timeout)
+	if err := conn.SetDeadline(deadline); err != nil {
+		return ntpSample{}, err
+	}
+
+	// The monotonic clock times the exchange, the wall clock places it
+	sentAt := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return ntpSample{}, err
+	}
+
+	reply := make([]byte, 1024)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			return ntpSample{}, err
+		}
+		receivedAt := time.Now()
+
+		if n < ntpPacketLen || reply[0]&0x07 != ntpModeServer || binary.BigEndian.Uint64(reply[24:32]) != cookie {
+			continue
+		}
+		return parseNTPReply(reply[:n], sentAt, receivedAt)
+	}
+}
+
+// parseNTPReply decodes a server reply and computes the offset and delay
+// from the four timestamps of the exchange
+func parseNTPReply(b []byte, sentAt, receivedAt time.Time) (ntpSample, error) {
+	sample := ntpSample{
+		leap:           int(b[0] >> 6),
+		version:        int(b[0] >> 3 & 0x07),
+		stratum:        int(b[1]),
+		precision:      int(int8(b[3])),
+		rootDelay:      ntpShort(binary.BigEndian.Uint32(b[4:8])),
+		rootDispersion: ntpShort(binary.BigEndian.Uint32(b[8:12])),
+		refID:          refID(b[1], b[12:16]),
+	}
+	if sample.stratum == 0 {
+		return sample, kissOfDeath{code: sample.refID}
+	}
+
+	transmit := binary.BigEndian.Uint64(b[40:48])
+	if transmit == 0 {
+		return sample, errors.New("reply without a transmit timestamp")
+	}
+	t1 := sentAt
+	t2 := ntpTime(binary.BigEndian.Uint64(b[32:40]))
+	t3 := ntpTime(transmit)
+	t4 := receivedAt
+
+	sample.offset = (t2.Sub(t1) + t3.Sub(t4)) / 2
+	sample.delay = max(t4.Sub(t1)-t3.Sub(t2), 0)
+	return sample, nil
+}
+
+// ntpTime converts a 64-bit NTP timestamp. Timestamps with the top bit
+// clear are taken to be in the era starting in 2036.
+func ntpTime(ts uint64) time.Time {
+	seconds := int64(ts >> 32)
+	if seconds&0x80000000 == 0 {
+		seconds += 1 << 32
+	}
+	fraction := int64(ts&0xffffffff) * int64(time.Second) >> 32
+	return time.Unix(seconds-ntpEpochOffset, fraction)
+}
+
+// ntpShort converts a 32-bit NTP duration, in seconds and 16-bit fractions
+func ntpShort(v uint32) time.Duration {
+	return time.Duration(int64(v) * int64(time.Second) >> 16)
+}
+
+// refID formats the reference ID of a reply: the kiss code of stratum 0,
+// the source name of primary servers and the upstream address of others
+func refID(stratum byte, b []byte) string {
+	if stratum <= 1 {
+		name := make([]byte, 0, 4)
+		for _, c := range b {
+			if c < ' ' || c > '~' {
+				break
+			}
+			name = append(name, c)
+		}
+		return string(name)
+	}
+	return net.IP(b).String()
+}
+
+// leapIndicator describes the leap second warning of a reply
+func leapIndicator(leap int) string {
+	switch leap {
+	case 1:
+		return "insert"
+	case 2:
+		return "delete"
+	case 3:
+		return "unsynchronized"
+	default:
+		return "none"
+	}
+}
+
+// formatOffset formats a clock offset in milliseconds with its sign
+func formatOffset(offset time.Duration) string {
+	return fmt.Sprintf("%+.3f ms", milliseconds(offset))
+}
+
+func ntpSummary(out Output, target Target, delays *rttStats, offsets []time.Duration, elapsed time.Duration) {
+	out.Printf("")
+	out.Printf("--- %s NTP statistics ---", target.Host)
+	out.Printf("%d requests sent, %d replies, %.6g%% loss, time %dms",
+		delays.sent, delays.received, delays.loss(), elapsed.Milliseconds())
+
+	event := map[string]any{
+		"sent":     delays.sent,
+		"received": delays.received,
+		"loss":     roundPercent(delays.loss()),
+	}
+	if len(offsets) > 0 {
+		var sum time.Duration
+		minOffset, maxOffset := offsets[0], offsets[0]
+		for _, offset := range offsets {
+			sum += offset
+			minOffset = min(minOffset, offset)
+			maxOffset = max(maxOffset, offset)
+		}
+		avg := sum / time.Duration(len(offsets))
+		jitter := offsetJitter(offsets)
+
+		out.Printf("offset min/avg/max = %+.3f/%+.3f/%+.3f ms, jitter %.3f ms",
+			milliseconds(minOffset), milliseconds(avg), milliseconds(maxOffset), milliseconds(jitter))
+		out.Printf("delay min/avg/max = %.3f/%.3f/%.3f ms",
+			milliseconds(delays.min), milliseconds(delays.avg()), milliseconds(delays.max))
+
+		event["offset"] = roundMilliseconds(avg)
+		event["delay"] = roundMilliseconds(delays.avg())
+		event["jitter"] = roundMilliseconds(jitter)
+	}
+	out.Event("ntp", event)
+}
+
+// offsetJitter returns the root mean square of the differences between
+// successive offsets, the way NTP estimates jitter
+func offsetJitter(offsets []time.Duration) time.Duration {
+	if len(offsets) < 2 {
+		return 0
+	}
+	var sumSq float64
+	for i := 1; i < len(offsets); i++ {
+		diff := milliseconds(offsets[i] - offsets[i-1])
+		sumSq += diff * diff
+	}
+	return time.Duration(math.Sqrt(sumSq/float64(len(offsets)-1)) * float64(time.Millisecond))
+}
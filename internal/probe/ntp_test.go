@@ -0,0 +1,156 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ntpTimestamp converts a time to a 64-bit NTP timestamp
+func ntpTimestamp(t time.Time) uint64 {
+	seconds := uint64(t.Unix()+ntpEpochOffset) & 0xffffffff
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// ntpReply builds a server reply to a request, received and transmitted
+// by the server at the given times
+func ntpReply(request []byte, stratum byte, refID string, received, transmitted time.Time) []byte {
+	reply := make([]byte, ntpPacketLen)
+	reply[0] = ntpVersion<<3 | ntpModeServer
+	reply[1] = stratum
+	reply[3] = 0xec // 2^-20 s
+	copy(reply[12:16], refID)
+	copy(reply[24:32], request[40:48])
+	binary.BigEndian.PutUint64(reply[32:40], ntpTimestamp(received))
+	binary.BigEndian.PutUint64(reply[40:48], ntpTimestamp(transmitted))
+	return reply
+}
+
+func TestParseNTPReply(t *testing.T) {
+	sentAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	receivedAt := sentAt.Add(30 * time.Millisecond)
+	// The server clock is 100 ms ahead and took 10 ms to reply, the
+	// request and the reply took 10 ms each
+	request := make([]byte, ntpPacketLen)
+	reply := ntpReply(request, 2, "\xc0\x00\x02\x01",
+		sentAt.Add(110*time.Millisecond), sentAt.Add(120*time.Millisecond))
+
+	sample, err := parseNTPReply(reply, sentAt, receivedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := (sample.offset - 100*time.Millisecond).Abs(); d > time.Microsecond {
+		t.Errorf("got offset %s, want 100ms", sample.offset)
+	}
+	if d := (sample.delay - 20*time.Millisecond).Abs(); d > time.Microsecond {
+		t.Errorf("got delay %s, want 20ms", sample.delay)
+	}
+	if sample.stratum != 2 || sample.refID != "192.0.2.1" || sample.precision != -20 {
+		t.Errorf("got stratum %d, ref ID %s and precision %d", sample.stratum, sample.refID, sample.precision)
+	}
+
+	_, err = parseNTPReply(ntpReply(request, 0, "RATE", sentAt, sentAt), sentAt, receivedAt)
+	var kiss kissOfDeath
+	if !errors.As(err, &kiss) || kiss.code != "RATE" {
+		t.Errorf("got %v for a stratum 0 reply, want a RATE Kiss-o'-Death", err)
+	}
+}
+
+// ntpServer answers NTP requests on a local port with the replies built by
+// reply for each request, in order. No reply is sent for nil ones.
+func ntpServer(t *testing.T, reply func(seq int, request []byte, received time.Time) []byte) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		request := make([]byte, 1024)
+		for seq := 1; ; seq++ {
+			n, from, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			if b := reply(seq, request[:n], time.Now()); b != nil {
+				conn.WriteTo(b, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestNTPQuery(t *testing.T) {
+	// Server clock offsets of the replies, the second request gets a
+	// Kiss-o'-Death. Replies are sent 20 ms after the request is received
+	// and timestamped halfway, as if it took 10 ms each way.
+	offsets := map[int]time.Duration{1: 100 * time.Millisecond, 3: 110 * time.Millisecond, 4: 90 * time.Millisecond}
+	port := ntpServer(t, func(seq int, request []byte, received time.Time) []byte {
+		if seq == 2 {
+			return ntpReply(request, 0, "RATE", received, received)
+		}
+		time.Sleep(20 * time.Millisecond)
+		at := received.Add(10 * time.Millisecond).Add(offsets[seq])
+		return ntpReply(request, 2, "\xc0\x00\x02\x01", at, at)
+	})
+
+	target := Target{Host: "127.0.0.1", IP: net.ParseIP("127.0.0.1"), Port: port}
+	s := ntpSettings{count: 4, interval: 10 * time.Millisecond, timeout: time.Second}
+	out := &recorder{}
+	if err := runNTP(context.Background(), target, s, out); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+
+	if !slices.ContainsFunc(out.lines, func(line string) bool { return strings.HasSuffix(line, "ntp_seq=2 Kiss-o'-Death RATE") }) {
+		t.Errorf("Kiss-o'-Death not reported:\n%s", strings.Join(out.lines, "\n"))
+	}
+	if samples := out.find("sample"); len(samples) != 3 {
+		t.Errorf("got %d samples, want 3", len(samples))
+	}
+
+	summaries := out.find("ntp")
+	if len(summaries) != 1 {
+		t.Fatalf("got %d ntp events, want 1", len(summaries))
+	}
+	summary := summaries[0]
+	if summary["sent"] != 4 || summary["received"] != 3 || summary["loss"] != 25.0 {
+		t.Errorf("got sent %v, received %v, loss %v, want 4, 3 and 25", summary["sent"], summary["received"], summary["loss"])
+	}
+	// The jitter is the root mean square of the differences of successive
+	// offsets, 10 and -20 ms
+	for key, want := range map[string]float64{
+		"offset": 100,
+		"delay":  20,
+		"jitter": math.Sqrt((10*10 + 20*20) / 2.0),
+	} {
+		value, _ := summary[key].(float64)
+		if math.Abs(value-want) > 5 {
+			t.Errorf("got %s %v ms, want about %.3f", key, summary[key], want)
+		}
+	}
+}
+
+func TestNTPAccessDenied(t *testing.T) {
+	port := ntpServer(t, func(seq int, request []byte, received time.Time) []byte {
+		return ntpReply(request, 0, "DENY", received, received)
+	})
+
+	target := Target{Host: "127.0.0.1", IP: net.ParseIP("127.0.0.1"), Port: port}
+	s := ntpSettings{count: 4, interval: 10 * time.Millisecond, timeout: time.Second}
+	out := &recorder{}
+	err := runNTP(context.Background(), target, s, out)
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("got %v, want access to be denied", err)
+	}
+	if summaries := out.find("ntp"); len(summaries) != 1 || summaries[0]["sent"] != 1 {
+		t.Errorf("got ntp events %v, want one after a single request", summaries)
+	}
+}
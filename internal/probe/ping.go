@@ -0,0 +1,220 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// pingSettings are the options of the native_ping backend
+type pingSettings struct {
+	count    int
+	interval time.Duration
+	size     int // Payload bytes
+	ttl      int
+	timeout  time.Duration // Wait for replies after the last request
+}
+
+func parsePing(opts Options) (s pingSettings, err error) {
+	if s.count, err = opts.Int("count", 4, 1, 100); err != nil {
+		return s, err
+	}
+	if s.interval, err = opts.Seconds("interval", time.Second, 200*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	if s.size, err = opts.Int("size", 56, 0, 65000); err != nil {
+		return s, err
+	}
+	if s.ttl, err = opts.Int("ttl", 64, 1, 255); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 30*time.Second); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// runPing sends ICMP echo requests to the target, printing replies in the
+// format of the ping tool, followed by the usual statistics
+func runPing(ctx context.Context, target Target, s pingSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+
+	conn, err := listenICMP(target.IsIPv6(), false, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.setTTL(s.ttl); err != nil {
+		return fmt.Errorf("cannot set TTL: %w", err)
+	}
+
+	headerLen := 28
+	if conn.ipv6 {
+		headerLen = 48
+	}
+	out.Printf("PING %s (%s) %d(%d) bytes of data.", target.Host, target.IP, s.size, s.size+headerLen)
+
+	messages, stopReceiving := receiveICMP(conn)
+	defer close(stopReceiving)
+
+	payload := make([]byte, s.size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	var (
+		stats    rttStats
+		errors   int
+		sentAt   = map[int]time.Time{}
+		answered = map[int]bool{}
+		seq      = 0
+		start    = time.Now()
+		finish   <-chan time.Time // Set once the last request is sent
+	)
+	send := time.NewTimer(0)
+	defer send.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			pingSummary(out, target, &stats, errors, time.Since(start))
+			return ctx.Err()
+
+		case <-send.C:
+			seq++
+			// Timestamped first, the reply may be read before sendEcho returns
+			sentAt[seq] = time.Now()
+			if err := conn.sendEcho(target.IP, seq, payload); err != nil {
+				out.Printf("ping: sendto: %v", err)
+			}
+			stats.sent++
+			if seq < s.count {
+				send.Reset(s.interval)
+			} else {
+				finish = time.After(s.timeout)
+			}
+
+		case msg, ok := <-messages:
+			if !ok {
+				return fmt.Errorf("receiving replies failed")
+			}
+
+			if msg.kind == echoReply {
+				sent, exists := sentAt[msg.seq]
+				if !exists {
+					continue
+				}
+				rtt := msg.at.Sub(sent)
+				line := fmt.Sprintf("%d bytes from %s: icmp_seq=%d", msg.size, msg.from, msg.seq)
+				if msg.ttl >= 0 {
+					line += fmt.Sprintf(" ttl=%d", msg.ttl)
+				}
+				line += " time=" + formatRTT(rtt)
+				if answered[msg.seq] {
+					line += " (DUP!)"
+				} else {
+					stats.add(rtt)
+					answered[msg.seq] = true
+				}
+				out.Printf("%s", line)
+			} else {
+				id, echoSeq, ok := originalEcho(msg.original, conn.ipv6)
+				if !ok || id != conn.id {
+					continue
+				}
+				if _, exists := sentAt[echoSeq]; !exists {
+					continue
+				}
+				errors++
+				answered[echoSeq] = true
+				out.Printf("From %s icmp_seq=%d %s", msg.from, echoSeq, msg.description)
+			}
+
+			if seq == s.count && len(answered) == s.count {
+				pingSummary(out, target, &stats, errors, time.Since(start))
+				return nil
+			}
+
+		case <-finish:
+			pingSummary(out, target, &stats, errors, time.Since(start))
+			return nil
+		}
+	}
+}
+
+// receiveICMP reads messages from conn in the background until it is closed
+// or the returned stop channel is closed
+func receiveICMP(conn *icmpConn) (<-chan *icmpMessage, chan struct{}) {
+	messages := make(chan *icmpMessage)
+	stop := make(chan struct{})
+
+	go func() {
+		defer close(messages)
+		for {
+			msg, err := conn.read(time.Time{})
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return messages, stop
+}
+
+// pingSummary prints the statistics of the probes and sends them as a ping
+// event, with round-trip times in milliseconds, null when none was answered
+func pingSummary(out Output, target Target, stats *rttStats, errors int, elapsed time.Duration) {
+	out.Printf("")
+	out.Printf("--- %s ping statistics ---", target.Host)
+
+	line := fmt.Sprintf("%d packets transmitted, %d received", stats.sent, stats.received)
+	if errors > 0 {
+		line += fmt.Sprintf(", +%d errors", errors)
+	}
+	out.Printf("%s, %.6g%% packet loss, time %dms", line, stats.loss(), elapsed.Milliseconds())
+
+	event := map[string]any{
+		"target":   target.Host,
+		"address":  target.IP.String(),
+		"sent":     stats.sent,
+		"received": stats.received,
+		"errors":   errors,
+		"loss":     roundPercent(stats.loss()),
+		"min":      nil,
+		"avg":      nil,
+		"max":      nil,
+		"mdev":     nil,
+	}
+	if stats.received > 0 {
+		out.Printf("%s", stats.summary())
+		event["min"] = roundMilliseconds(stats.min)
+		event["avg"] = roundMilliseconds(stats.avg())
+		event["max"] = roundMilliseconds(stats.max)
+		event["mdev"] = roundMilliseconds(stats.stdev())
+	}
+	out.Event("ping", event)
+}
+
+// formatRTT formats a round-trip time with three significant digits, the
+// way ping does
+func formatRTT(rtt time.Duration) string {
+	ms := milliseconds(rtt)
+	switch {
+	case ms < 1:
+		return fmt.Sprintf("%.3f ms", ms)
+	case ms < 10:
+		return fmt.Sprintf("%.2f ms", ms)
+	case ms < 100:
+		return fmt.Sprintf("%.1f ms", ms)
+	default:
+		return fmt.Sprintf("%.0f ms", ms)
+	}
+}
@@ -0,0 +1,55 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPingLoopback(t *testing.T) {
+	for _, address := range []string{"127.0.0.1", "::1"} {
+		t.Run(address, func(t *testing.T) {
+			ip := net.ParseIP(address)
+			conn, err := listenICMP(ip.To4() == nil, false, nil)
+			if err != nil {
+				t.Skipf("no ICMP socket: %v", err)
+			}
+			conn.Close()
+
+			s := pingSettings{count: 3, interval: 200 * time.Millisecond, size: 56, ttl: 64, timeout: time.Second}
+			out := &recorder{}
+			if err := runPing(context.Background(), Target{Host: address, IP: ip}, s, out); err != nil {
+				t.Fatalf("ping failed: %v", err)
+			}
+
+			replies := 0
+			for _, line := range out.lines {
+				if strings.Contains(line, "bytes from "+address) {
+					replies++
+				}
+			}
+			if replies != 3 {
+				t.Errorf("got %d replies, want 3:\n%s", replies, strings.Join(out.lines, "\n"))
+			}
+
+			summaries := out.find("ping")
+			if len(summaries) != 1 {
+				t.Fatalf("got %d ping events, want 1", len(summaries))
+			}
+			summary := summaries[0]
+			if summary["sent"] != 3 || summary["received"] != 3 || summary["loss"] != 0.0 {
+				t.Errorf("got sent %v, received %v, loss %v, want 3, 3 and 0", summary["sent"], summary["received"], summary["loss"])
+			}
+			if summary["address"] != address {
+				t.Errorf("got address %v, want %s", summary["address"], address)
+			}
+			for _, key := range []string{"min", "avg", "max", "mdev"} {
+				if _, ok := summary[key].(float64); !ok {
+					t.Errorf("%s is %v, want a round-trip time", key, summary[key])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// pmtuTTL is the TTL of path MTU probes, enough to reach any target
+const pmtuTTL = 64
+
+// pmtuSettings are the options of the pmtu backend
+type pmtuSettings struct {
+	mode    string // "icmp" or "udp"
+	maxSize int    // Largest packet size tried, IP header included
+	tries   int    // Probes per size
+	timeout time.Duration
+}
+
+func parsePMTU(opts Options) (s pmtuSettings, err error) {
+	if s.mode, err = opts.Enum("mode", "icmp", "icmp", "udp"); err != nil {
+		return s, err
+	}
+	if s.maxSize, err = opts.Int("max_size", 1500, 68, 65000); err != nil {
+		return s, err
+	}
+	if s.tries, err = opts.Int("tries", 2, 1, 5); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// pmtuAttempt is the outcome of probing the path with a packet size
+type pmtuAttempt struct {
+	size   int
+	result string // "ok", "too_big", "lost" or "unreachable"
+	from   net.IP // Who answered, nil when lost or too big locally
+	rtt    time.Duration
+	mtu    int    // MTU reported by a packet too big error, zero when unknown
+	local  bool   // Too big for the outgoing interface
+	flag   string // Why the target is unreachable, in traceroute notation
+}
+
+// runPMTU binary searches the largest packet that reaches the target
+// unfragmented, streaming each attempt, then reports the path MTU and the
+// hop that limits it
+func runPMTU(ctx context.Context, target Target, s pmtuSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+	if !pmtuSupported {
+		return errors.New("path MTU discovery is only supported on Linux")
+	}
+
+	// Probes are no smaller than the minimum MTU every link must carry
+	headerLen, minSize := 28, 68
+	if target.IsIPv6() {
+		headerLen, minSize = 48, 1280
+	}
+	if s.maxSize < minSize {
+		return fmt.Errorf("option max_size must be at least %d for IPv6 targets", minSize)
+	}
+
+	t, err := newTracer(target, s.mode, dontFragment)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	out.Printf("PMTU discovery to %s (%s), %d to %d byte packets", target.Host, target.IP, minSize, s.maxSize)
+
+	messages, stopReceiving := receiveICMP(t.conn)
+	defer close(stopReceiving)
+
+	attempt := func(size int) (pmtuAttempt, error) {
+		t.payload = make([]byte, size-headerLen)
+		a, err := probePMTU(ctx, t, size, s, messages)
+		if err != nil {
+			return a, err
+		}
+		out.Printf("%s", formatAttempt(a))
+		out.Event("attempt", attemptEvent(a))
+		return a, nil
+	}
+
+	first, err := attempt(s.maxSize)
+	if err != nil {
+		return err
+	}
+	if first.result == "ok" {
+		pmtuSummary(out, s.maxSize, nil, s.maxSize)
+		return nil
+	}
+	if first.result == "unreachable" {
+		return fmt.Errorf("%s is unreachable", target.Host)
+	}
+
+	// The largest size known to pass is searched up to the smallest known
+	// to fail. A reported MTU is tried next, as it is usually right, and
+	// confirmed by trying one byte more once it passes.
+	found, below := 0, s.maxSize
+	limit, hint := first, first.mtu
+	for {
+		if below <= minSize {
+			return fmt.Errorf("no %d byte probe reached %s, it may not answer %s probes", minSize, target.Host, s.mode)
+		}
+		if found == below-1 {
+			break
+		}
+
+		size := (found + below) / 2
+		switch {
+		case hint > found && hint < below:
+			size = hint
+		case found == 0:
+			size = minSize
+		}
+		hinted := size == hint
+		hint = 0
+
+		a, err := attempt(size)
+		if err != nil {
+			return err
+		}
+		switch a.result {
+		case "ok":
+			found = size
+			if hinted {
+				hint = size + 1
+			}
+		case "unreachable":
+			return fmt.Errorf("%s is unreachable", target.Host)
+		default:
+			below, limit, hint = size, a, a.mtu
+		}
+	}
+
+	pmtuSummary(out, found, &limit, s.maxSize)
+	return nil
+}
+
+// probePMTU sends the probes of a packet size and returns the first answer
+func probePMTU(ctx context.Context, t *tracer, size int, s pmtuSettings, messages <-chan *icmpMessage) (pmtuAttempt, error) {
+	a := pmtuAttempt{size: size, result: "lost"}
+
+	probes, err := t.probe(ctx, slices.Repeat([]int{pmtuTTL}, s.tries), s.timeout, messages)
+	if err != nil {
+		if tooBigLocally(err) {
+			a.result = "too_big"
+			a.local = true
+			return a, nil
+		}
+		return a, err
+	}
+
+	for _, p := range probes {
+		if !p.answered {
+			continue
+		}
+		a.from = p.from
+		a.rtt = p.rtt
+		switch {
+		case p.reached:
+			a.result = "ok"
+		case p.mtu > 0 || p.flag == "!F":
+			a.result = "too_big"
+			a.mtu = p.mtu
+		default:
+			a.result = "unreachable"
+			a.flag = p.flag
+		}
+		return a, nil
+	}
+	return a, nil
+}
+
+func formatAttempt(a pmtuAttempt) string {
+	line := fmt.Sprintf("%5d bytes: ", a.size)
+	switch {
+	case a.result == "ok":
+		return line + fmt.Sprintf("reply from %s  %s", a.from, formatRTT(a.rtt))
+	case a.local:
+		return line + "too big for the local interface"
+	case a.result == "too_big" && a.mtu > 0:
+		return line + fmt.Sprintf("too big for %s, MTU %d", a.from, a.mtu)
+	case a.result == "too_big":
+		return line + fmt.Sprintf("too big for %s", a.from)
+	case a.result == "unreachable":
+		return line + fmt.Sprintf("unreachable from %s %s", a.from, a.flag)
+	default:
+		return line + "no reply"
+	}
+}
+
+// attemptEvent describes an attempt, with the round-trip time in
+// milliseconds, null when unanswered
+func attemptEvent(a pmtuAttempt) map[string]any {
+	event := map[string]any{
+		"size":    a.size,
+		"result":  a.result,
+		"address": "",
+		"rtt":     nil,
+	}
+	if a.from != nil {
+		event["address"] = a.from.String()
+		event["rtt"] = roundMilliseconds(a.rtt)
+	}
+	if a.mtu > 0 {
+		event["mtu"] = a.mtu
+	}
+	return event
+}
+
+// pmtuSummary reports the path MTU and what limits it, which is the answer
+// to the smallest size found too big, nil when the largest size passed
+func pmtuSummary(out Output, mtu int, limit *pmtuAttempt, maxSize int) {
+	out.Printf("")
+	out.Printf("Path MTU: %d bytes", mtu)
+
+	event := map[string]any{
+		"mtu":        mtu,
+		"limited":    limit != nil,
+		"hop":        "",
+		"local":      false,
+		"black_hole": false,
+	}
+	switch {
+	case limit == nil:
+		out.Printf("All probes up to %d bytes got through", maxSize)
+	case limit.local:
+		out.Printf("Larger packets exceed the MTU of the local interface")
+		event["local"] = true
+	case limit.result == "lost":
+		out.Printf("Larger packets are dropped without an ICMP error, a possible PMTU black hole")
+		event["black_hole"] = true
+	default:
+		line := fmt.Sprintf("Larger packets are too big for %s", limit.from)
+		if limit.mtu > 0 {
+			line += fmt.Sprintf(", which reported MTU %d", limit.mtu)
+			event["reported_mtu"] = limit.mtu
+		}
+		out.Printf("%s", line)
+		event["hop"] = limit.from.String()
+	}
+	out.Event("pmtu", event)
+}
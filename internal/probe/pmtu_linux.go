@@ -0,0 +1,43 @@
+package probe
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// pmtuSupported reports whether probes can be sent with the don't fragment
+// flag on this platform
+const pmtuSupported = true
+
+// dontFragment makes a socket send its packets with the don't fragment flag,
+// ignoring the path MTU the kernel learned, so that every probe size is
+// tried on the wire. Packets larger than the interface MTU fail with
+// EMSGSIZE instead of being fragmented.
+func dontFragment(network, address string, c syscall.RawConn) error {
+	var err error
+	controlErr := c.Control(func(fd uintptr) {
+		var family int
+		if family, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN); err != nil {
+			return
+		}
+		if family == unix.AF_INET6 {
+			if err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE); err == nil {
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1)
+			}
+		} else {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+		}
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	return err
+}
+
+// tooBigLocally reports whether sending a probe failed because it exceeds
+// the MTU of the outgoing interface
+func tooBigLocally(err error) bool {
+	return errors.Is(err, unix.EMSGSIZE)
+}
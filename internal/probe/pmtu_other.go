@@ -0,0 +1,20 @@
+//go:build !linux
+
+package probe
+
+import (
+	"errors"
+	"syscall"
+)
+
+// pmtuSupported reports whether probes can be sent with the don't fragment
+// flag on this platform
+const pmtuSupported = false
+
+func dontFragment(network, address string, c syscall.RawConn) error {
+	return errors.New("the don't fragment flag is not supported on this platform")
+}
+
+func tooBigLocally(err error) bool {
+	return false
+}
@@ -0,0 +1,202 @@
+// Package probe implements the built-in diagnostic backends, which run
+// in-process instead of depending on tools installed on the host.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Target is the resolved destination of a probe
+type Target struct {
+	Host string // As entered by the user, domain or IP, or the whole input for queries and lists
+	IP   net.IP // Resolved address, nil for commands that ignore the target
+	Port int    // Zero when no port was given
+	URL  string // For backends taking URL targets
+
+	// Resolve resolves other hosts the probe is led to, such as redirect
+	// locations, applying the same checks as to the target
+	Resolve func(ctx context.Context, host string) (net.IP, error)
+}
+
+// IsIPv6 reports whether the target is reached over IPv6
+func (t Target) IsIPv6() bool {
+	return t.IP.To4() == nil
+}
+
+// Output receives what a probe produces
+type Output interface {
+	// Printf sends a line of text
+	Printf(format string, args ...any)
+	// Event sends a structured event, such as a traceroute hop
+	Event(name string, data map[string]any)
+}
+
+// Backend is a built-in command type
+type Backend struct {
+	// Check validates the options of a command using the backend
+	Check func(opts Options) error
+	// Run probes the target until done or ctx is cancelled
+	Run func(ctx context.Context, target Target, opts Options, out Output) error
+	// Target is the kind of target the backend takes
+	Target TargetKind
+}
+
+// TargetKind is the kind of target a backend takes
+type TargetKind string
+
+const (
+	// TargetHost is an IP address or domain, resolved before the run
+	TargetHost TargetKind = "host"
+	// TargetName is an IP address or domain as entered, not resolved
+	TargetName TargetKind = "name"
+	// TargetURL is an http or https URL
+	TargetURL TargetKind = "url"
+	// TargetQuery is an IP address, prefix, AS number or domain as entered
+	TargetQuery TargetKind = "query"
+	// TargetList is a list of IP addresses, domains and prefixes as entered,
+	// each resolved by the backend
+	TargetList TargetKind = "list"
+)
+
+var backends = map[string]Backend{
+	"native_ping":       newBackend(TargetHost, parsePing, runPing),
+	"tcping":            newBackend(TargetHost, parseTCPing, runTCPing),
+	"native_traceroute": newBackend(TargetHost, parseTraceroute, runTraceroute),
+	"native_mtr":        newBackend(TargetHost, parseMTR, runMTR),
+	"http_probe":        newBackend(TargetURL, parseHTTP, runHTTP),
+	"tls_inspect":       newBackend(TargetHost, parseTLS, runTLS),
+	"dns_lookup":        newBackend(TargetName, parseDNS, runDNS),
+	"whois":             newBackend(TargetQuery, parseWhois, runWhois),
+	"pmtu":              newBackend(TargetHost, parsePMTU, runPMTU),
+	"ntp_query":         newBackend(TargetHost, parseNTP, runNTP),
+	"fping":             newBackend(TargetList, parseFping, runFping),
+}
+
+// Lookup returns the backend of a command type
+func Lookup(name string) (Backend, bool) {
+	backend, exists := backends[name]
+	return backend, exists
+}
+
+// newBackend builds a backend taking a kind of target from a function
+// parsing its settings from the options and a function running it with
+// those settings
+func newBackend[S any](target TargetKind, parse func(Options) (S, error), run func(context.Context, Target, S, Output) error) Backend {
+	return Backend{
+		Target: target,
+		Check: func(opts Options) error {
+			_, err := parse(opts)
+			return err
+		},
+		Run: func(ctx context.Context, target Target, opts Options, out Output) error {
+			settings, err := parse(opts)
+			if err != nil {
+				return err
+			}
+			return run(ctx, target, settings, out)
+		},
+	}
+}
+
+// Options are the settings of a probe run, as configured for the command and
+// overridden by user parameters. Missing or empty options use the default.
+type Options map[string]string
+
+// Int returns an integer option between min and max
+func (o Options) Int(name string, def, min, max int) (int, error) {
+	value := o[name]
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be an integer", name)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("option %s must be between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// Seconds returns a duration option given in seconds, fractions allowed
+func (o Options) Seconds(name string, def, min, max time.Duration) (time.Duration, error) {
+	value := o[name]
+	if value == "" {
+		return def, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be a number of seconds", name)
+	}
+	d := time.Duration(seconds * float64(time.Second))
+	if d < min || d > max {
+		return 0, fmt.Errorf("option %s must be between %g and %g seconds", name, min.Seconds(), max.Seconds())
+	}
+	return d, nil
+}
+
+// Enum returns an option that must be one of values
+func (o Options) Enum(name, def string, values ...string) (string, error) {
+	value := o[name]
+	if value == "" {
+		return def, nil
+	}
+	if !slices.Contains(values, value) {
+		return "", fmt.Errorf("option %s must be one of %v", name, values)
+	}
+	return value, nil
+}
+
+// List returns a comma separated list option
+func (o Options) List(name string, def []string) []string {
+	value := o[name]
+	if value == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// SplitTargets splits a list of targets separated by commas or whitespace
+func SplitTargets(input string) []string {
+	return strings.FieldsFunc(input, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// requireTarget fails for probes run without a resolved target
+func requireTarget(target Target) error {
+	if target.IP == nil {
+		return fmt.Errorf("a target is required")
+	}
+	return nil
+}
+
+// milliseconds converts a duration to fractional milliseconds for display
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// roundMilliseconds converts a duration to milliseconds rounded to the
+// microsecond for events
+func roundMilliseconds(d time.Duration) float64 {
+	return math.Round(milliseconds(d)*1000) / 1000
+}
+
+// roundPercent rounds a percentage to one decimal for events
+func roundPercent(p float64) float64 {
+	return math.Round(p*10) / 10
+}
@@ -0,0 +1,34 @@
+package probe
+
+import "fmt"
+
+// event is a structured event sent by a backend
+type event struct {
+	name string
+	data map[string]any
+}
+
+// recorder is an Output keeping what a backend sends
+type recorder struct {
+	lines  []string
+	events []event
+}
+
+func (r *recorder) Printf(format string, args ...any) {
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Event(name string, data map[string]any) {
+	r.events = append(r.events, event{name, data})
+}
+
+// find returns the data of the events of the given name
+func (r *recorder) find(name string) []map[string]any {
+	var found []map[string]any
+	for _, e := range r.events {
+		if e.name == name {
+			found = append(found, e.data)
+		}
+	}
+	return found
+}
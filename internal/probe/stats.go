@@ -0,0 +1,64 @@
+package probe
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// rttStats accumulates the round-trip times of a series of probes
+type rttStats struct {
+	sent     int
+	received int
+	last     time.Duration
+	min      time.Duration
+	max      time.Duration
+	sum      time.Duration
+	sumSq    float64 // Sum of squared round-trip times in ms²
+}
+
+// add records a probe that was answered
+func (s *rttStats) add(rtt time.Duration) {
+	if s.received == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	s.received++
+	s.last = rtt
+	s.sum += rtt
+	s.sumSq += milliseconds(rtt) * milliseconds(rtt)
+}
+
+// loss returns the percentage of probes that were not answered
+func (s *rttStats) loss() float64 {
+	if s.sent == 0 {
+		return 0
+	}
+	return float64(s.sent-s.received) * 100 / float64(s.sent)
+}
+
+func (s *rttStats) avg() time.Duration {
+	if s.received == 0 {
+		return 0
+	}
+	return s.sum / time.Duration(s.received)
+}
+
+// stdev returns the standard deviation of the round-trip times, which ping
+// calls mdev
+func (s *rttStats) stdev() time.Duration {
+	if s.received == 0 {
+		return 0
+	}
+	avg := milliseconds(s.avg())
+	variance := s.sumSq/float64(s.received) - avg*avg
+	return time.Duration(math.Sqrt(max(variance, 0)) * float64(time.Millisecond))
+}
+
+// summary formats the round-trip times the way ping does
+func (s *rttStats) summary() string {
+	return fmt.Sprintf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms",
+		milliseconds(s.min), milliseconds(s.avg()), milliseconds(s.max), milliseconds(s.stdev()))
+}
@@ -0,0 +1,126 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// tcpingSettings are the options of the tcping backend
+type tcpingSettings struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration // Per connection attempt
+	port     int           // Used when the target has no port
+}
+
+func parseTCPing(opts Options) (s tcpingSettings, err error) {
+	if s.count, err = opts.Int("count", 4, 1, 100); err != nil {
+		return s, err
+	}
+	if s.interval, err = opts.Seconds("interval", time.Second, 200*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 30*time.Second); err != nil {
+		return s, err
+	}
+	if s.port, err = opts.Int("port", 80, 1, 65535); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// runTCPing times TCP handshakes with the target, which works where ICMP is
+// filtered. Each connection is closed as soon as it is established.
+func runTCPing(ctx context.Context, target Target, s tcpingSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+
+	port := target.Port
+	if port == 0 {
+		port = s.port
+	}
+	address := net.JoinHostPort(target.IP.String(), strconv.Itoa(port))
+	out.Printf("TCPING %s (%s) port %d", target.Host, target.IP, port)
+
+	var stats rttStats
+	start := time.Now()
+	for seq := 1; seq <= s.count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+				tcpingSummary(out, target, port, &stats, time.Since(start))
+				return ctx.Err()
+			case <-time.After(s.interval):
+			}
+		}
+
+		rtt, err := connectTCP(ctx, address, s.timeout)
+		if ctx.Err() != nil {
+			tcpingSummary(out, target, port, &stats, time.Since(start))
+			return ctx.Err()
+		}
+		stats.sent++
+		if err != nil {
+			out.Printf("From %s: tcp_seq=%d %s", address, seq, connectFailure(err))
+			continue
+		}
+		stats.add(rtt)
+		out.Printf("Connected to %s: tcp_seq=%d time=%s", address, seq, formatRTT(rtt))
+	}
+
+	tcpingSummary(out, target, port, &stats, time.Since(start))
+	return nil
+}
+
+// connectTCP establishes a connection to address and returns how long the
+// handshake took
+func connectTCP(ctx context.Context, address string, timeout time.Duration) (time.Duration, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	rtt := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return rtt, nil
+}
+
+// connectFailure describes why a connection attempt failed
+func connectFailure(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "Connection refused"
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return "Host unreachable"
+	case errors.Is(err, syscall.ENETUNREACH):
+		return "Network unreachable"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "Connection timed out"
+	}
+
+	// The system's message, without the address repeated
+	var syscallErr *os.SyscallError
+	if errors.As(err, &syscallErr) {
+		return syscallErr.Err.Error()
+	}
+	return err.Error()
+}
+
+func tcpingSummary(out Output, target Target, port int, stats *rttStats, elapsed time.Duration) {
+	out.Printf("")
+	out.Printf("--- %s port %d tcping statistics ---", target.Host, port)
+	out.Printf("%d connections attempted, %d successful, %.6g%% failed, time %dms",
+		stats.sent, stats.received, stats.loss(), elapsed.Milliseconds())
+
+	if stats.received > 0 {
+		out.Printf("%s", stats.summary())
+	}
+}
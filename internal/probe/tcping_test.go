@@ -0,0 +1,77 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcping runs the tcping backend against a port of the loopback address
+func tcping(t *testing.T, port int) *recorder {
+	t.Helper()
+	// The port of the target takes precedence over the configured one
+	target := Target{Host: "localhost", IP: net.ParseIP("127.0.0.1"), Port: port}
+	s := tcpingSettings{count: 3, interval: 10 * time.Millisecond, timeout: time.Second, port: 9}
+	out := &recorder{}
+	if err := runTCPing(context.Background(), target, s, out); err != nil {
+		t.Fatalf("tcping failed: %v", err)
+	}
+	return out
+}
+
+// hasPrefix reports whether one of the lines starts with prefix
+func hasPrefix(lines []string, prefix string) bool {
+	return slices.ContainsFunc(lines, func(line string) bool { return strings.HasPrefix(line, prefix) })
+}
+
+func TestTCPingConnected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	address := listener.Addr().String()
+	out := tcping(t, listener.Addr().(*net.TCPAddr).Port)
+	for seq := 1; seq <= 3; seq++ {
+		if prefix := fmt.Sprintf("Connected to %s: tcp_seq=%d time=", address, seq); !hasPrefix(out.lines, prefix) {
+			t.Errorf("missing line %q in:\n%s", prefix, strings.Join(out.lines, "\n"))
+		}
+	}
+	if !hasPrefix(out.lines, "3 connections attempted, 3 successful, 0% failed") || !hasPrefix(out.lines, "rtt min/avg/max/mdev = ") {
+		t.Errorf("statistics missing in:\n%s", strings.Join(out.lines, "\n"))
+	}
+}
+
+func TestTCPingRefused(t *testing.T) {
+	// A port that was just listened on is closed once the listener is
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	out := tcping(t, listener.Addr().(*net.TCPAddr).Port)
+	for seq := 1; seq <= 3; seq++ {
+		if want := fmt.Sprintf("From %s: tcp_seq=%d Connection refused", address, seq); !slices.Contains(out.lines, want) {
+			t.Errorf("missing line %q in:\n%s", want, strings.Join(out.lines, "\n"))
+		}
+	}
+	if !hasPrefix(out.lines, "3 connections attempted, 0 successful, 100% failed") || hasPrefix(out.lines, "rtt ") {
+		t.Errorf("wrong statistics in:\n%s", strings.Join(out.lines, "\n"))
+	}
+}
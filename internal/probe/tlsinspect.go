@@ -0,0 +1,205 @@
+package probe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tlsSettings are the options of the tls_inspect backend
+type tlsSettings struct {
+	port    int // Used when the target has no port
+	sni     string
+	alpn    []string
+	timeout time.Duration
+}
+
+func parseTLS(opts Options) (s tlsSettings, err error) {
+	if s.port, err = opts.Int("port", 443, 1, 65535); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 10*time.Second, time.Second, 60*time.Second); err != nil {
+		return s, err
+	}
+	s.sni = opts["sni"]
+	s.alpn = opts.List("alpn", []string{"h2", "http/1.1"})
+	return s, nil
+}
+
+// runTLS performs a TLS handshake with the target, with the host entered by
+// the user as server name, and reports the negotiated parameters and the
+// certificate chain. The chain is verified against the system roots
+// separately, so that invalid certificates are shown as well.
+func runTLS(ctx context.Context, target Target, s tlsSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+
+	port := target.Port
+	if port == 0 {
+		port = s.port
+	}
+	address := net.JoinHostPort(target.IP.String(), strconv.Itoa(port))
+
+	serverName := s.sni
+	if serverName == "" && net.ParseIP(target.Host) == nil {
+		serverName = target.Host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	dialer := tls.Dialer{Config: &tls.Config{
+		ServerName:         serverName,
+		NextProtos:         s.alpn,
+		InsecureSkipVerify: true,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+
+	if serverName != "" {
+		out.Printf("Connected to %s, server name %s", address, serverName)
+	} else {
+		out.Printf("Connected to %s, no server name", address)
+	}
+
+	alpn := state.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "none"
+	}
+	verifyErr := verifyChain(state.PeerCertificates, serverName, target.IP)
+	verification := "OK"
+	if verifyErr != nil {
+		verification = "failed: " + verifyErr.Error()
+	}
+
+	out.Printf("Protocol: %s", tls.VersionName(state.Version))
+	out.Printf("Cipher: %s", tls.CipherSuiteName(state.CipherSuite))
+	out.Printf("ALPN: %s", alpn)
+	out.Printf("OCSP stapling: %s", yesNo(len(state.OCSPResponse) > 0))
+	out.Printf("Verification: %s", verification)
+
+	chain := make([]map[string]any, len(state.PeerCertificates))
+	for i, cert := range state.PeerCertificates {
+		out.Printf("")
+		out.Printf("Certificate %d:", i)
+		out.Printf("  Subject: %s", cert.Subject)
+		out.Printf("  Issuer: %s", cert.Issuer)
+		if sans := certificateSANs(cert); len(sans) > 0 {
+			out.Printf("  SANs: %s", strings.Join(sans, ", "))
+		}
+		out.Printf("  Valid: %s to %s (%s)", cert.NotBefore.UTC().Format(time.DateTime), cert.NotAfter.UTC().Format(time.DateTime), validity(cert, time.Now()))
+		out.Printf("  Key: %s, signature %s", publicKeyDescription(cert), cert.SignatureAlgorithm)
+		out.Printf("  Serial: %s", hex.EncodeToString(cert.SerialNumber.Bytes()))
+		out.Printf("  SHA-256 fingerprint: %s", fingerprint(cert))
+
+		chain[i] = map[string]any{
+			"subject":     cert.Subject.String(),
+			"issuer":      cert.Issuer.String(),
+			"sans":        certificateSANs(cert),
+			"not_before":  cert.NotBefore.UTC().Format(time.RFC3339),
+			"not_after":   cert.NotAfter.UTC().Format(time.RFC3339),
+			"key":         publicKeyDescription(cert),
+			"signature":   cert.SignatureAlgorithm.String(),
+			"serial":      hex.EncodeToString(cert.SerialNumber.Bytes()),
+			"fingerprint": fingerprint(cert),
+		}
+	}
+
+	event := map[string]any{
+		"address":      address,
+		"server_name":  serverName,
+		"version":      tls.VersionName(state.Version),
+		"cipher":       tls.CipherSuiteName(state.CipherSuite),
+		"alpn":         state.NegotiatedProtocol,
+		"ocsp_stapled": len(state.OCSPResponse) > 0,
+		"verified":     verifyErr == nil,
+		"chain":        chain,
+	}
+	if verifyErr != nil {
+		event["verify_error"] = verifyErr.Error()
+	}
+	out.Event("tls", event)
+	return nil
+}
+
+// verifyChain verifies the certificates presented by a server against the
+// system roots, for the server name or, without one, the IP address
+func verifyChain(certs []*x509.Certificate, serverName string, ip net.IP) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	if serverName == "" {
+		opts.DNSName = ip.String()
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// certificateSANs lists the DNS names and IP addresses a certificate is for
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
+
+// validity describes where now falls in the validity window of a
+// certificate
+func validity(cert *x509.Certificate, now time.Time) string {
+	switch {
+	case now.Before(cert.NotBefore):
+		return "not yet valid"
+	case now.After(cert.NotAfter):
+		return fmt.Sprintf("expired %d days ago", int(now.Sub(cert.NotAfter).Hours()/24))
+	default:
+		return fmt.Sprintf("expires in %d days", int(cert.NotAfter.Sub(now).Hours()/24))
+	}
+}
+
+func publicKeyDescription(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d bits", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
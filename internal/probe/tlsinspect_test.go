@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTLSInspect(t *testing.T) {
+	var (
+		serverNames []string
+		mu          sync.Mutex
+	)
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			serverNames = append(serverNames, hello.ServerName)
+			mu.Unlock()
+			return nil, nil
+		},
+	}
+	// Connections are closed right after the handshake
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	address := server.Listener.Addr().(*net.TCPAddr)
+	target := Target{Host: "127.0.0.1", IP: address.IP, Port: address.Port}
+	cert := server.Certificate()
+
+	tests := []struct {
+		name       string
+		sni        string
+		alpn       []string
+		serverName string
+		protocol   string
+	}{
+		{"server name", "example.com", []string{"h2", "http/1.1"}, "example.com", "h2"},
+		// Addresses are not sent as server names
+		{"address", "", []string{"http/1.1"}, "", "http/1.1"},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := tlsSettings{sni: test.sni, alpn: test.alpn, timeout: 5 * time.Second}
+			out := &recorder{}
+			// The test certificate is self-signed, which is reported
+			// rather than failing the handshake
+			if err := runTLS(context.Background(), target, s, out); err != nil {
+				t.Fatalf("inspection failed: %v", err)
+			}
+
+			mu.Lock()
+			sent := serverNames[i]
+			mu.Unlock()
+			if sent != test.serverName {
+				t.Errorf("server got server name %q, want %q", sent, test.serverName)
+			}
+
+			events := out.find("tls")
+			if len(events) != 1 {
+				t.Fatalf("got %d tls events, want 1", len(events))
+			}
+			event := events[0]
+			if event["server_name"] != test.serverName || event["alpn"] != test.protocol {
+				t.Errorf("got server name %v and ALPN %v, want %q and %s", event["server_name"], event["alpn"], test.serverName, test.protocol)
+			}
+			if event["verified"] != false || !strings.Contains(event["verify_error"].(string), "unknown authority") {
+				t.Errorf("got verified %v with error %v, want an unknown authority", event["verified"], event["verify_error"])
+			}
+			if !slices.ContainsFunc(out.lines, func(line string) bool { return strings.HasPrefix(line, "Verification: failed: ") }) {
+				t.Errorf("failed verification not shown:\n%s", strings.Join(out.lines, "\n"))
+			}
+
+			chain := event["chain"].([]map[string]any)
+			if len(chain) != 1 {
+				t.Fatalf("got a chain of %d certificates, want 1", len(chain))
+			}
+			if chain[0]["fingerprint"] != fingerprint(cert) || chain[0]["subject"] != cert.Subject.String() {
+				t.Errorf("got certificate %v, want the test server's", chain[0])
+			}
+			if sans := chain[0]["sans"].([]string); !slices.Contains(sans, "example.com") || !slices.Contains(sans, "127.0.0.1") {
+				t.Errorf("got SANs %v, want example.com and 127.0.0.1", sans)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	traceroutePort    = 33434 // Destination port of the first UDP probe
+	traceroutePayload = 32
+)
+
+// tracerouteSettings are the options of the native_traceroute backend
+type tracerouteSettings struct {
+	mode    string // "udp" or "icmp"
+	maxHops int
+	queries int           // Probes per hop
+	timeout time.Duration // Wait for the replies of a hop
+}
+
+func parseTraceroute(opts Options) (s tracerouteSettings, err error) {
+	if s.mode, err = opts.Enum("mode", "udp", "udp", "icmp"); err != nil {
+		return s, err
+	}
+	if s.maxHops, err = opts.Int("max_hops", 30, 1, 255); err != nil {
+		return s, err
+	}
+	if s.queries, err = opts.Int("queries", 3, 1, 10); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 2*time.Second, 100*time.Millisecond, 10*time.Second); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// hopProbe is a probe sent with the TTL of a hop
+type hopProbe struct {
+	sentAt   time.Time
+	answered bool
+	from     net.IP
+	rtt      time.Duration
+	reached  bool   // Answered by the target
+	flag     string // Why the target is unreachable, in traceroute notation
+	mtu      int    // MTU reported by a packet too big error
+}
+
+// tracer sends the probes of a traceroute and matches the ICMP messages
+// received in response
+type tracer struct {
+	target  Target
+	conn    *icmpConn      // Receives the replies, and sends ICMP probes
+	udp     net.PacketConn // Sends UDP probes, nil in ICMP mode
+	udpPort int            // Source port of UDP probes
+	payload []byte
+	seq     int
+}
+
+// runTraceroute probes the path to the target one hop at a time, streaming
+// each hop as text and as a hop event
+func runTraceroute(ctx context.Context, target Target, s tracerouteSettings, out Output) error {
+	if err := requireTarget(target); err != nil {
+		return err
+	}
+
+	t, err := newTracer(target, s.mode, nil)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	headerLen := 20
+	if t.conn.ipv6 {
+		headerLen = 40
+	}
+	out.Printf("traceroute to %s (%s), %d hops max, %d byte packets", target.Host, target.IP, s.maxHops, headerLen+8+traceroutePayload)
+
+	messages, stopReceiving := receiveICMP(t.conn)
+	defer close(stopReceiving)
+
+	for ttl := 1; ttl <= s.maxHops; ttl++ {
+		probes, err := t.probe(ctx, slices.Repeat([]int{ttl}, s.queries), s.timeout, messages)
+		if err != nil {
+			return err
+		}
+		out.Printf("%s", formatHop(ttl, probes))
+		out.Event("hop", hopEvent(ttl, probes))
+
+		if lastHop(probes) {
+			break
+		}
+	}
+	return nil
+}
+
+// newTracer opens the sockets of a tracer, adjusted by control before they
+// are bound when set
+func newTracer(target Target, mode string, control func(network, address string, c syscall.RawConn) error) (*tracer, error) {
+	v6 := target.IsIPv6()
+	conn, err := listenICMP(v6, true, control)
+	if err != nil {
+		return nil, err
+	}
+	t := &tracer{target: target, conn: conn, payload: make([]byte, traceroutePayload)}
+
+	if mode == "udp" {
+		network, address := "udp4", "0.0.0.0:0"
+		if v6 {
+			network, address = "udp6", "[::]:0"
+		}
+		lc := net.ListenConfig{Control: control}
+		t.udp, err = lc.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("cannot open a UDP socket: %w", err)
+		}
+		t.udpPort = t.udp.LocalAddr().(*net.UDPAddr).Port
+	}
+	return t, nil
+}
+
+func (t *tracer) Close() {
+	t.conn.Close()
+	if t.udp != nil {
+		t.udp.Close()
+	}
+}
+
+// probe sends a probe with each of the TTLs and waits for their replies
+// until the timeout
+func (t *tracer) probe(ctx context.Context, ttls []int, timeout time.Duration, messages <-chan *icmpMessage) ([]hopProbe, error) {
+	probes := make([]hopProbe, len(ttls))
+	pending := map[int]int{} // Index of the probe by sequence number
+	for i, ttl := range ttls {
+		t.seq++
+		probes[i].sentAt = time.Now()
+		if err := t.send(ttl, t.seq); err != nil {
+			return nil, fmt.Errorf("sending probe failed: %w", err)
+		}
+		pending[t.seq] = i
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return probes, nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil, errors.New("receiving replies failed")
+			}
+			seq, ok := t.match(msg)
+			i, exists := pending[seq]
+			if !ok || !exists {
+				continue
+			}
+			delete(pending, seq)
+
+			p := &probes[i]
+			p.answered = true
+			p.from = msg.from
+			p.rtt = msg.at.Sub(p.sentAt)
+			p.mtu = msg.mtu
+			switch {
+			case msg.kind == echoReply || isPortUnreachable(msg, t.conn.ipv6):
+				p.reached = true
+			case msg.kind == unreachable:
+				p.flag = unreachableFlag(t.conn.ipv6, msg.code)
+			}
+		}
+	}
+	return probes, nil
+}
+
+// send sends a probe with the given TTL and sequence number, which is the
+// echo sequence number of ICMP probes and sets the destination port of UDP
+// probes
+func (t *tracer) send(ttl, seq int) error {
+	if t.udp == nil {
+		if err := t.conn.setTTL(ttl); err != nil {
+			return err
+		}
+		return t.conn.sendEcho(t.target.IP, seq, t.payload)
+	}
+
+	var err error
+	if t.conn.ipv6 {
+		err = ipv6.NewPacketConn(t.udp).SetHopLimit(ttl)
+	} else {
+		err = ipv4.NewPacketConn(t.udp).SetTTL(ttl)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = t.udp.WriteTo(t.payload, &net.UDPAddr{IP: t.target.IP, Port: traceroutePort + seq})
+	return err
+}
+
+// match returns the sequence number of the probe an ICMP message responds to
+func (t *tracer) match(msg *icmpMessage) (int, bool) {
+	if t.udp == nil {
+		if msg.kind == echoReply {
+			return msg.seq, true
+		}
+		id, seq, ok := originalEcho(msg.original, t.conn.ipv6)
+		return seq, ok && id == t.conn.id
+	}
+
+	if msg.kind == echoReply {
+		return 0, false
+	}
+	srcPort, dstPort, ok := originalUDP(msg.original, t.conn.ipv6)
+	return dstPort - traceroutePort, ok && srcPort == t.udpPort
+}
+
+// lastHop reports whether a hop reached the target or reported it
+// unreachable
+func lastHop(probes []hopProbe) bool {
+	for _, p := range probes {
+		if p.reached || p.flag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHop formats a hop the way traceroute does, repeating the address
+// only when it changes between probes
+func formatHop(ttl int, probes []hopProbe) string {
+	line := fmt.Sprintf("%2d ", ttl)
+	var last net.IP
+	for _, p := range probes {
+		if !p.answered {
+			line += " *"
+			continue
+		}
+		if !p.from.Equal(last) {
+			line += " " + p.from.String()
+			last = p.from
+		}
+		line += "  " + formatRTT(p.rtt)
+		if p.flag != "" {
+			line += " " + p.flag
+		}
+	}
+	return line
+}
+
+// hopEvent describes a hop for clients drawing a table, with the address
+// that answered first and the round-trip time of each probe in
+// milliseconds, null when unanswered
+func hopEvent(ttl int, probes []hopProbe) map[string]any {
+	address := ""
+	rtts := make([]any, len(probes))
+	reached := false
+	flag := ""
+	for i, p := range probes {
+		if !p.answered {
+			continue
+		}
+		if address == "" {
+			address = p.from.String()
+		}
+		rtts[i] = roundMilliseconds(p.rtt)
+		reached = reached || p.reached
+		if flag == "" {
+			flag = p.flag
+		}
+	}
+
+	event := map[string]any{
+		"ttl":     ttl,
+		"address": address,
+		"rtts":    rtts,
+		"reached": reached,
+	}
+	if flag != "" {
+		event["flag"] = flag
+	}
+	return event
+}
+
+// unreachableFlag returns the traceroute notation of a destination
+// unreachable code
+func unreachableFlag(v6 bool, code int) string {
+	if v6 {
+		switch code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!X"
+		case 3:
+			return "!H"
+		}
+	} else {
+		switch code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!H"
+		case 2:
+			return "!P"
+		case 4:
+			return "!F"
+		case 9, 10, 13:
+			return "!X"
+		}
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
@@ -0,0 +1,311 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWhoisResponse is the size at which WHOIS and RDAP responses are cut
+const maxWhoisResponse = 1 << 20
+
+// whoisSettings are the options of the whois backend
+type whoisSettings struct {
+	mode         string // "whois" or "rdap"
+	server       string // WHOIS server queried first, host with an optional port
+	rdapServer   string // Base URL of the RDAP service
+	maxReferrals int
+	timeout      time.Duration
+}
+
+func parseWhois(opts Options) (s whoisSettings, err error) {
+	if s.mode, err = opts.Enum("mode", "whois", "whois", "rdap"); err != nil {
+		return s, err
+	}
+	if s.maxReferrals, err = opts.Int("max_referrals", 3, 0, 5); err != nil {
+		return s, err
+	}
+	if s.timeout, err = opts.Seconds("timeout", 10*time.Second, time.Second, 60*time.Second); err != nil {
+		return s, err
+	}
+	s.server = opts["server"]
+	if s.server == "" {
+		s.server = "whois.iana.org"
+	}
+	s.rdapServer = opts["rdap_server"]
+	if s.rdapServer == "" {
+		s.rdapServer = "https://rdap.org"
+	}
+	return s, nil
+}
+
+// runWhois looks up the registration of an IP address, prefix, AS number or
+// domain over WHOIS, following referrals to the authoritative server, or
+// over RDAP
+func runWhois(ctx context.Context, target Target, s whoisSettings, out Output) error {
+	if target.Host == "" {
+		return fmt.Errorf("a target is required")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if s.mode == "rdap" {
+		return runRDAP(ctx, target.Host, s.rdapServer, out)
+	}
+
+	server := whoisAddress(s.server)
+	queried := map[string]bool{}
+	for referrals := 0; ; referrals++ {
+		queried[server] = true
+		out.Printf("%% Querying %s for %s", server, target.Host)
+
+		// Referred servers are resolved like targets, the configured one is
+		// trusted
+		address := server
+		if referrals > 0 {
+			host, port, _ := net.SplitHostPort(server)
+			ip, err := target.Resolve(ctx, host)
+			if err != nil {
+				return fmt.Errorf("cannot follow referral to %s: %w", server, err)
+			}
+			address = net.JoinHostPort(ip.String(), port)
+		}
+
+		response, err := queryWhois(ctx, address, target.Host)
+		if err != nil {
+			return fmt.Errorf("query to %s failed: %w", server, err)
+		}
+		out.Printf("")
+		for _, line := range response {
+			out.Printf("%s", line)
+		}
+
+		referral := whoisReferral(response)
+		if referral == "" || queried[whoisAddress(referral)] {
+			return nil
+		}
+		if referrals == s.maxReferrals {
+			out.Printf("%% Not following more than %d referrals", s.maxReferrals)
+			return nil
+		}
+		out.Printf("")
+		server = whoisAddress(referral)
+	}
+}
+
+// queryWhois sends a query to a WHOIS server and returns the lines of its
+// response
+func queryWhois(ctx context.Context, address, query string) ([]string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := io.WriteString(conn, query+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(conn, maxWhoisResponse))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return lines, scanner.Err()
+}
+
+// whoisReferral returns the WHOIS server a response refers to, as IANA,
+// ARIN and domain registries do, or an empty string
+func whoisReferral(response []string) string {
+	for _, line := range response {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "refer", "referralserver", "registrar whois server", "whois server":
+			value = strings.TrimSpace(value)
+			// Other referrals, such as rwhois:// ones, are not followed
+			if strings.Contains(value, "://") && !strings.HasPrefix(value, "whois://") {
+				continue
+			}
+			value = strings.TrimPrefix(value, "whois://")
+			value, _, _ = strings.Cut(value, "/")
+			if value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// whoisAddress adds the WHOIS port to a server without one
+func whoisAddress(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "43")
+}
+
+// runRDAP looks up a query over RDAP, printing the main fields of the
+// response and sending all of it as an rdap event
+func runRDAP(ctx context.Context, query, server string, out Output) error {
+	url := strings.TrimSuffix(server, "/") + "/" + rdapPath(query)
+	out.Printf("%% Querying %s", url)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("RDAP query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("no RDAP record found for %s", query)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("RDAP server returned status: %s", resp.Status)
+	}
+
+	var object map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWhoisResponse)).Decode(&object); err != nil {
+		return fmt.Errorf("invalid RDAP response: %w", err)
+	}
+
+	out.Printf("")
+	printRDAP(out, object)
+	out.Event("rdap", object)
+	return nil
+}
+
+// rdapPath returns the RDAP path of a query: AS numbers are looked up as
+// autnums, IP addresses and prefixes as IP networks, others as domains
+func rdapPath(query string) string {
+	if len(query) > 2 && strings.EqualFold(query[:2], "AS") {
+		if asn, err := strconv.ParseUint(query[2:], 10, 32); err == nil {
+			return "autnum/" + strconv.FormatUint(asn, 10)
+		}
+	}
+	if net.ParseIP(query) != nil {
+		return "ip/" + query
+	}
+	if _, _, err := net.ParseCIDR(query); err == nil {
+		return "ip/" + query
+	}
+	return "domain/" + query
+}
+
+// printRDAP prints the fields of an RDAP object that WHOIS users look for
+func printRDAP(out Output, object map[string]any) {
+	field := func(label, value string) {
+		if value != "" {
+			out.Printf("%-14s %s", label+":", value)
+		}
+	}
+	text := func(key string) string {
+		value, _ := object[key].(string)
+		return value
+	}
+	number := func(key string) string {
+		if value, ok := object[key].(float64); ok {
+			return fmt.Sprintf("%.0f", value)
+		}
+		return ""
+	}
+
+	field("Object", text("objectClassName"))
+	field("Handle", text("handle"))
+	field("Name", text("name"))
+	field("Domain", text("ldhName"))
+	if start, end := text("startAddress"), text("endAddress"); start != "" {
+		field("Range", start+" - "+end)
+	}
+	if start, end := number("startAutnum"), number("endAutnum"); start != "" {
+		field("AS numbers", start+" - "+end)
+	}
+	field("Type", text("type"))
+	field("Country", text("country"))
+	field("Parent", text("parentHandle"))
+	field("Status", strings.Join(rdapStrings(object["status"]), ", "))
+	field("WHOIS server", text("port43"))
+
+	for _, item := range rdapList(object["events"]) {
+		action, _ := item["eventAction"].(string)
+		date, _ := item["eventDate"].(string)
+		field("Event", action+" "+date)
+	}
+	for _, item := range rdapList(object["nameservers"]) {
+		name, _ := item["ldhName"].(string)
+		field("Name server", name)
+	}
+	for _, item := range rdapList(object["entities"]) {
+		handle, _ := item["handle"].(string)
+		entity := handle
+		if name := vcardName(item["vcardArray"]); name != "" {
+			entity += " " + name
+		}
+		if roles := rdapStrings(item["roles"]); len(roles) > 0 {
+			entity += " (" + strings.Join(roles, ", ") + ")"
+		}
+		field("Entity", strings.TrimSpace(entity))
+	}
+}
+
+// rdapList returns the objects of a JSON array
+func rdapList(value any) []map[string]any {
+	items, _ := value.([]any)
+	var list []map[string]any
+	for _, item := range items {
+		if object, ok := item.(map[string]any); ok {
+			list = append(list, object)
+		}
+	}
+	return list
+}
+
+// rdapStrings returns the strings of a JSON array
+func rdapStrings(value any) []string {
+	items, _ := value.([]any)
+	var list []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// vcardName returns the formatted name of a jCard, as found in the
+// vcardArray of RDAP entities
+func vcardName(value any) string {
+	card, _ := value.([]any)
+	if len(card) < 2 {
+		return ""
+	}
+	properties, _ := card[1].([]any)
+	for _, property := range properties {
+		fields, _ := property.([]any)
+		if len(fields) >= 4 && fields[0] == "fn" {
+			name, _ := fields[3].(string)
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRDAPPath(t *testing.T) {
+	tests := []struct {
+		query string
+		path  string
+	}{
+		{"AS13335", "autnum/13335"},
+		{"as64500", "autnum/64500"},
+		{"asus.com", "domain/asus.com"},
+		{"AS", "domain/AS"},
+		{"AS99999999999", "domain/AS99999999999"},
+		{"192.0.2.1", "ip/192.0.2.1"},
+		{"2001:db8::1", "ip/2001:db8::1"},
+		{"198.51.100.0/24", "ip/198.51.100.0/24"},
+		{"example.com", "domain/example.com"},
+	}
+	for _, test := range tests {
+		if path := rdapPath(test.query); path != test.path {
+			t.Errorf("rdapPath(%q) = %q, want %q", test.query, path, test.path)
+		}
+	}
+}
+
+func TestWhoisReferral(t *testing.T) {
+	tests := []struct {
+		name     string
+		response []string
+		referral string
+	}{
+		{"iana", []string{"% IANA WHOIS server", "", "refer:        whois.arin.net", "", "inetnum:      8.0.0.0 - 8.255.255.255"}, "whois.arin.net"},
+		{"arin", []string{"NetRange:       192.0.2.0 - 192.0.2.255", "ReferralServer:  whois://whois.ripe.net"}, "whois.ripe.net"},
+		{"arin with port", []string{"ReferralServer:  whois://whois.example.net:4343/"}, "whois.example.net:4343"},
+		{"rwhois", []string{"ReferralServer:  rwhois://rwhois.example.net:4321"}, ""},
+		{"registrar", []string{"   Domain Name: EXAMPLE.COM", "   Registrar WHOIS Server: whois.markmonitor.com"}, "whois.markmonitor.com"},
+		{"none", []string{"inetnum:        192.0.2.0 - 192.0.2.255", "netname:        EXAMPLE"}, ""},
+	}
+	for _, test := range tests {
+		if referral := whoisReferral(test.response); referral != test.referral {
+			t.Errorf("%s: got referral %q, want %q", test.name, referral, test.referral)
+		}
+	}
+}
+
+// whoisServer serves WHOIS queries with a fixed response on a local port,
+// and returns its address and a function listing the queries received
+func whoisServer(t *testing.T, response ...string) (string, func() []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var (
+		queries []string
+		mu      sync.Mutex
+	)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			query, _ := bufio.NewReader(conn).ReadString('\n')
+			mu.Lock()
+			queries = append(queries, strings.TrimSpace(query))
+			mu.Unlock()
+			conn.Write([]byte(strings.Join(response, "\r\n") + "\r\n"))
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(queries)
+	}
+}
+
+func TestWhoisReferrals(t *testing.T) {
+	// Each server refers to the next one
+	last, lastQueries := whoisServer(t, "inetnum: 192.0.2.0 - 192.0.2.255", "netname: EXAMPLE-NET")
+	middle, _ := whoisServer(t, "NetRange: 192.0.2.0 - 192.0.2.255", "ReferralServer: whois://"+last)
+	first, firstQueries := whoisServer(t, "refer: "+middle)
+
+	target := Target{
+		Host: "192.0.2.1",
+		Resolve: func(ctx context.Context, host string) (net.IP, error) {
+			return net.ParseIP(host), nil
+		},
+	}
+
+	tests := []struct {
+		name         string
+		maxReferrals int
+		queried      []string
+		cutOff       bool
+	}{
+		{"one referral", 1, []string{first, middle}, true},
+		{"all referrals", 2, []string{first, middle, last}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := whoisSettings{mode: "whois", server: first, maxReferrals: test.maxReferrals, timeout: 5 * time.Second}
+			out := &recorder{}
+			if err := runWhois(context.Background(), target, s, out); err != nil {
+				t.Fatalf("whois failed: %v", err)
+			}
+
+			var queried []string
+			for _, line := range out.lines {
+				if server, found := strings.CutPrefix(line, "% Querying "); found {
+					queried = append(queried, strings.TrimSuffix(server, " for 192.0.2.1"))
+				}
+			}
+			if !slices.Equal(queried, test.queried) {
+				t.Errorf("queried %v, want %v", queried, test.queried)
+			}
+			if cutOff := slices.Contains(out.lines, "% Not following more than 1 referrals"); cutOff != test.cutOff {
+				t.Errorf("referrals cut off: %v, want %v", cutOff, test.cutOff)
+			}
+			if shown := slices.Contains(out.lines, "netname: EXAMPLE-NET"); shown == test.cutOff {
+				t.Errorf("response of the last server shown: %v, want %v", shown, !test.cutOff)
+			}
+		})
+	}
+
+	if queries := firstQueries(); !slices.Equal(queries, []string{"192.0.2.1", "192.0.2.1"}) {
+		t.Errorf("first server got queries %q, want 192.0.2.1 twice", queries)
+	}
+	if queries := lastQueries(); len(queries) != 1 {
+		t.Errorf("last server got %d queries, want 1", len(queries))
+	}
+}
+
+func TestRDAP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/autnum/64500" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Accept") != "application/rdap+json" {
+			http.Error(w, "unexpected Accept header", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{
+			"objectClassName": "autnum",
+			"handle": "AS64500",
+			"name": "EXAMPLE-AS",
+			"startAutnum": 64500,
+			"endAutnum": 64500,
+			"status": ["active"],
+			"entities": [{"handle": "EX1", "roles": ["registrant"], "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Org"]]]}]
+		}`))
+	}))
+	defer server.Close()
+
+	s := whoisSettings{mode: "rdap", rdapServer: server.URL + "/", timeout: 5 * time.Second}
+	out := &recorder{}
+	if err := runWhois(context.Background(), Target{Host: "AS64500"}, s, out); err != nil {
+		t.Fatalf("RDAP query failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"% Querying " + server.URL + "/autnum/64500",
+		"Handle:        AS64500",
+		"AS numbers:    64500 - 64500",
+		"Entity:        EX1 Example Org (registrant)",
+	} {
+		if !slices.Contains(out.lines, want) {
+			t.Errorf("missing line %q in:\n%s", want, strings.Join(out.lines, "\n"))
+		}
+	}
+	objects := out.find("rdap")
+	if len(objects) != 1 || objects[0]["handle"] != "AS64500" {
+		t.Errorf("got rdap events %v, want the object of AS64500", objects)
+	}
+
+	err := runWhois(context.Background(), Target{Host: "asus.com"}, s, &recorder{})
+	if err == nil || !strings.Contains(err.Error(), "no RDAP record found for asus.com") {
+		t.Errorf("got error %v for a domain missing from the server", err)
+	}
+}
@@ -0,0 +1,90 @@
+package validator
+
+import (
+	"YALS/internal/config"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// ValidateParams checks user supplied parameter values against the
+// command's declared parameters and returns the rendered placeholder value
+// of every parameter, using defaults for the ones not supplied
+func ValidateParams(params []config.CommandParam, values map[string]any) (map[string]string, error) {
+	for name := range values {
+		if !slices.ContainsFunc(params, func(p config.CommandParam) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("unknown parameter: %s", name)
+		}
+	}
+
+	rendered := make(map[string]string, len(params))
+	for _, param := range params {
+		value, exists := values[param.Name]
+		if !exists || value == nil {
+			rendered[param.Name] = param.Placeholder(param.Default)
+			continue
+		}
+
+		parsed, err := parseParam(param, value)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", param.Label, err)
+		}
+		rendered[param.Name] = param.Placeholder(parsed)
+	}
+
+	return rendered, nil
+}
+
+// parseParam converts a JSON decoded value to the parameter's type and
+// checks it against the declared constraints. Values may also be given as
+// strings, as submitted by HTML form controls.
+func parseParam(param config.CommandParam, value any) (any, error) {
+	switch param.Type {
+	case config.ParamInt:
+		var n int
+		switch v := value.(type) {
+		case float64:
+			if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			n = int(v)
+		case string:
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("must be an integer")
+			}
+			n = i
+		default:
+			return nil, fmt.Errorf("must be an integer")
+		}
+		if n < *param.Min || n > *param.Max {
+			return nil, fmt.Errorf("must be between %d and %d", *param.Min, *param.Max)
+		}
+		return n, nil
+
+	case config.ParamEnum:
+		s, ok := value.(string)
+		if !ok || !slices.Contains(param.Values, s) {
+			return nil, fmt.Errorf("must be one of %v", param.Values)
+		}
+		return s, nil
+
+	case config.ParamBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("must be true or false")
+			}
+			return b, nil
+		default:
+			return nil, fmt.Errorf("must be true or false")
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown type %s", param.Type)
+	}
+}
@@ -2,9 +2,12 @@ package validator
 
 import (
 	"YALS/internal/dns"
+	"YALS/internal/probe"
 	"context"
 	"net"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -35,11 +38,45 @@ const (
 	IPAddress
 	// Domain represents a domain name
 	Domain
+	// URL represents an http or https URL
+	URL
+	// Prefix represents an IP prefix in CIDR notation
+	Prefix
+	// ASN represents an AS number, such as AS13335
+	ASN
+	// List represents several IP addresses, domains or prefixes separated by
+	// commas or spaces
+	List
 )
 
+// maxListLength is the length limit of lists of targets, which is higher
+// than that of single targets
+const maxListLength = 4096
+
 // ValidateInput validates the input and returns its type
 func ValidateInput(input string) InputType {
 
+	if len(input) > maxListLength {
+		return InvalidInput
+	}
+
+	// Lists are valid when each of their items is an IP address or domain
+	// without a port, or a prefix
+	if items := probe.SplitTargets(input); len(items) > 1 {
+		for _, item := range items {
+			switch ValidateInput(item) {
+			case IPAddress, Domain:
+				if _, port := extractHostPort(item); port != "" {
+					return InvalidInput
+				}
+			case Prefix:
+			default:
+				return InvalidInput
+			}
+		}
+		return List
+	}
+
 	// Check if input length exceeds 256 characters
 	if len(input) > 256 {
 		return InvalidInput
@@ -53,6 +90,27 @@ func ValidateInput(input string) InputType {
 		return InvalidInput
 	}
 
+	// URLs are valid when their host is an IP address or domain
+	if strings.Contains(input, "://") {
+		u := parseURL(input)
+		if u == nil {
+			return InvalidInput
+		}
+		switch ValidateInput(u.Host) {
+		case IPAddress, Domain:
+			return URL
+		}
+		return InvalidInput
+	}
+
+	// Prefixes and AS numbers are only taken by lookup commands
+	if _, _, err := net.ParseCIDR(input); err == nil {
+		return Prefix
+	}
+	if isASN(input) {
+		return ASN
+	}
+
 	// Extract host and port
 	host, port := extractHostPort(input)
 	if host == "" {
@@ -80,6 +138,25 @@ func ValidateInput(input string) InputType {
 	return InvalidInput
 }
 
+// LiteralIPVersion returns the IP version of a target that is an IP address,
+// or IPVersionAuto for domains and invalid input
+func LiteralIPVersion(input string) IPVersion {
+	input = strings.TrimSpace(input)
+	if u := parseURL(input); u != nil {
+		input = u.Host
+	}
+	host, _ := extractHostPort(input)
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return IPVersionAuto
+	case ip.To4() != nil:
+		return IPVersionIPv4
+	default:
+		return IPVersionIPv6
+	}
+}
+
 // ResolveDomain resolves a domain name to IP addresses using the DNS resolver
 func ResolveDomain(domain string) ([]net.IP, error) {
 	return ResolveDomainWithVersion(domain, dns.IPVersionAuto)
@@ -94,6 +171,16 @@ func ResolveDomainWithVersion(domain string, version dns.IPVersion) ([]net.IP, e
 	return resolver.ResolveWithVersion(ctx, domain, version)
 }
 
+// parseURL parses an http or https URL, returning nil for other input or
+// URLs carrying credentials
+func parseURL(input string) *url.URL {
+	u, err := url.Parse(input)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return nil
+	}
+	return u
+}
+
 // extractHostPort extracts host and port from input
 // Supports:
 // - IPv4: 192.168.1.1 or 192.168.1.1:8080
@@ -147,6 +234,15 @@ func extractHostPort(input string) (host, port string) {
 	return host, port
 }
 
+// isASN checks if the input is an AS number with its AS prefix
+func isASN(input string) bool {
+	if len(input) < 3 || !strings.EqualFold(input[:2], "AS") {
+		return false
+	}
+	_, err := strconv.ParseUint(input[2:], 10, 32)
+	return err == nil
+}
+
 // isValidDomain checks if the input is a valid domain name
 func isValidDomain(domain string) bool {
 	// Domain name validation regex
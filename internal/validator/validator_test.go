@@ -0,0 +1,23 @@
+package validator
+
+import "testing"
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		input string
+		want  InputType
+	}{
+		{"http://example.com/", URL},
+		{"https://192.0.2.1:8443/path?q=1", URL},
+		{"http://[2001:db8::1]/", URL},
+		{"http://a.com,b.com/", InvalidInput},
+		{"http://AS13335/", InvalidInput},
+		{"http://192.0.2.0%2F24/", InvalidInput},
+		{"ftp://example.com/", InvalidInput},
+	}
+	for _, test := range tests {
+		if got := ValidateInput(test.input); got != test.want {
+			t.Errorf("ValidateInput(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
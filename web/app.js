@@ -8,6 +8,13 @@ class YALSClient {
         this.isRunning = false;
         this.eventSource = null;
         this.abortController = null;
+        this.targetPlaceholders = {
+            host: 'Enter IP address or domain name',
+            name: 'Enter IP address or domain name',
+            url: 'Enter an http or https URL',
+            query: 'Enter IP address, prefix, AS number or domain name',
+            list: 'Enter IP addresses or domain names separated by commas, or a prefix'
+        };
 
         this.initElements();
         this.initSession();
@@ -19,6 +26,7 @@ class YALSClient {
         this.commandSelector = document.getElementById('commandSelect');
         this.targetInput = document.getElementById('targetInput');
         this.ipVersionSelect = document.getElementById('ipVersionSelect');
+        this.paramsRow = document.getElementById('paramsRow');
         this.executeBtn = document.getElementById('executeBtn');
         this.stopBtn = document.getElementById('stopBtn');
         this.terminalBody = document.getElementById('terminalBody');
@@ -139,9 +147,79 @@ class YALSClient {
 
         this.commandSelector.value = commandName;
 
+        const cmd = this.commands.find(c => c.name === commandName);
+        this.renderParams(cmd);
+        this.renderIPVersions(cmd);
+
         this.updateExecuteButton();
     }
 
+    renderIPVersions(cmd) {
+        const versions = cmd?.ip_versions || ['auto', 'ipv4', 'ipv6'];
+
+        Array.from(this.ipVersionSelect.options).forEach(option => {
+            const supported = versions.includes(option.value);
+            option.hidden = !supported;
+            option.disabled = !supported;
+        });
+
+        if (!versions.includes(this.ipVersionSelect.value) && versions.length > 0) {
+            this.ipVersionSelect.value = versions[0];
+        }
+    }
+
+    renderParams(cmd) {
+        const params = cmd?.params || [];
+        if (params.length === 0) {
+            this.paramsRow.innerHTML = '';
+            this.paramsRow.style.display = 'none';
+            return;
+        }
+
+        this.paramsRow.innerHTML = params.map(param => {
+            const name = this.escapeHtml(param.name);
+            const label = this.escapeHtml(param.label || param.name);
+
+            switch (param.type) {
+                case 'int':
+                    return `
+                        <label class="param-item">${label}
+                            <input type="number" class="param-input" data-param="${name}" min="${param.min}" max="${param.max}" value="${param.default}">
+                        </label>`;
+                case 'enum':
+                    return `
+                        <label class="param-item">${label}
+                            <select class="param-input" data-param="${name}">
+                                ${param.values.map(v => `<option value="${this.escapeHtml(v)}"${v === param.default ? ' selected' : ''}>${this.escapeHtml(v)}</option>`).join('')}
+                            </select>
+                        </label>`;
+                case 'bool':
+                    return `
+                        <label class="param-item">
+                            <input type="checkbox" class="param-input" data-param="${name}"${param.default ? ' checked' : ''}>${label}
+                        </label>`;
+                default:
+                    return '';
+            }
+        }).join('');
+        this.paramsRow.style.display = 'flex';
+    }
+
+    collectParams() {
+        const params = {};
+        this.paramsRow.querySelectorAll('.param-input').forEach(input => {
+            const name = input.dataset.param;
+            if (input.type === 'checkbox') {
+                params[name] = input.checked;
+            } else if (input.type === 'number') {
+                params[name] = Number(input.value);
+            } else {
+                params[name] = input.value;
+            }
+        });
+        return params;
+    }
+
     updateExecuteButton() {
         const hasCommand = this.selectedCommand !== null;
         const targetValue = this.targetInput.value.trim();
@@ -157,7 +235,7 @@ class YALSClient {
                 this.targetInput.disabled = true;
                 this.targetInput.value = '';
             } else {
-                this.targetInput.placeholder = 'Enter IP address or domain name';
+                this.targetInput.placeholder = this.targetPlaceholders[cmd.target_type] || this.targetPlaceholders.host;
                 this.targetInput.disabled = false;
             }
         }
@@ -183,6 +261,8 @@ class YALSClient {
         this.ipVersionSelect.disabled = true;
         this.disableCommandButtons();
         this.currentCommandId = null;
+        this.lastEventId = null;
+        this.commandCompleted = false;
         this.rateLimitInfo.style.display = 'none';
 
         this.appendOutput(`<span class="command-line">$ ${this.selectedCommand}${target ? ' ' + target : ''}</span>\n`, 'normal');
@@ -199,7 +279,8 @@ class YALSClient {
                     agent: 'localhost',
                     command: this.selectedCommand,
                     target: target || '',
-                    ip_version: this.ipVersionSelect.value
+                    ip_version: this.ipVersionSelect.value,
+                    params: this.collectParams()
                 }),
                 signal: this.abortController.signal
             });
@@ -211,34 +292,19 @@ class YALSClient {
 
             this.stopBtn.disabled = false;
 
-            const reader = response.body.getReader();
-            const decoder = new TextDecoder();
-            let buffer = '';
-
-            while (true) {
-                const { done, value } = await reader.read();
-                if (done) break;
-
-                buffer += decoder.decode(value, { stream: true });
-                const lines = buffer.split('\n\n');
-                buffer = lines.pop();
-
-                for (const line of lines) {
-                    if (line.startsWith('data: ')) {
-                        try {
-                            const data = JSON.parse(line.slice(6));
-                            this.handleSSEMessage(data);
-                        } catch (e) {
-                            console.error('Failed to parse SSE message:', e);
-                        }
-                    }
-                }
+            await this.readEventStream(response);
+            if (!this.commandCompleted && this.currentCommandId) {
+                await this.reattachCommand();
             }
 
         } catch (error) {
             if (error.name !== 'AbortError') {
-                console.error('Execute command error:', error);
-                this.appendOutput(`Error: ${this.escapeHtml(error.message)}\n`, 'error');
+                if (this.currentCommandId && !this.commandCompleted) {
+                    await this.reattachCommand();
+                } else {
+                    console.error('Execute command error:', error);
+                    this.appendOutput(`Error: ${this.escapeHtml(error.message)}\n`, 'error');
+                }
             }
         } finally {
             this.isRunning = false;
@@ -252,13 +318,99 @@ class YALSClient {
         }
     }
 
+    async readEventStream(response) {
+        const reader = response.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+
+        while (true) {
+            const { done, value } = await reader.read();
+            if (done) break;
+
+            buffer += decoder.decode(value, { stream: true });
+            const events = buffer.split('\n\n');
+            buffer = events.pop();
+
+            for (const event of events) {
+                for (const line of event.split('\n')) {
+                    if (line.startsWith('id: ')) {
+                        this.lastEventId = line.slice(4);
+                    } else if (line.startsWith('data: ')) {
+                        try {
+                            const data = JSON.parse(line.slice(6));
+                            this.handleSSEMessage(data);
+                        } catch (e) {
+                            console.error('Failed to parse SSE message:', e);
+                        }
+                    }
+                }
+            }
+        }
+    }
+
+    // Resume a command whose stream dropped, picking up after the last
+    // event received; the command keeps running on the server meanwhile
+    async reattachCommand() {
+        const commandId = this.currentCommandId;
+
+        for (let attempt = 1; attempt <= this.maxReconnectAttempts; attempt++) {
+            this.appendOutput(`*** Connection lost, reconnecting (${attempt}/${this.maxReconnectAttempts}) ***\n`, 'error');
+            await new Promise(resolve => setTimeout(resolve, this.reconnectDelay));
+            if (this.commandCompleted || this.currentCommandId !== commandId) return;
+
+            try {
+                this.abortController = new AbortController();
+
+                const headers = {};
+                if (this.lastEventId) {
+                    headers['Last-Event-ID'] = this.lastEventId;
+                }
+                const response = await fetch(`/api/attach?session_id=${encodeURIComponent(this.currentSessionID)}&command_id=${encodeURIComponent(commandId)}`, {
+                    headers,
+                    signal: this.abortController.signal
+                });
+
+                if (response.status === 404) {
+                    this.appendOutput('Error: Command not found or already completed\n', 'error');
+                    return;
+                }
+                if (!response.ok) continue;
+
+                await this.readEventStream(response);
+                if (this.commandCompleted) return;
+            } catch (error) {
+                if (error.name === 'AbortError') return;
+                console.error('Reattach error:', error);
+            }
+        }
+
+        this.appendOutput('Error: Lost connection to the command\n', 'error');
+    }
+
     handleSSEMessage(data) {
         if (data.command_id && !this.currentCommandId) {
             this.currentCommandId = data.command_id;
             this.stopBtn.disabled = false;
         }
 
+        if (data.type === 'queued') {
+            this.showQueueStatus(data);
+            return;
+        }
+
+        if (data.type === 'started') {
+            this.clearQueueStatus();
+            return;
+        }
+
+        if (data.type === 'snapshot') {
+            this.showSnapshot(data);
+            return;
+        }
+
         if (data.type === 'complete') {
+            this.commandCompleted = true;
+            this.clearQueueStatus();
             this.isRunning = false;
             this.executeBtn.disabled = false;
             this.stopBtn.disabled = true;
@@ -268,11 +420,59 @@ class YALSClient {
             this.currentCommandId = null;
         }
 
+        if (data.output && this.snapshot) {
+            this.snapshot.remove();
+            this.snapshot = null;
+        }
+
         if (data.error) {
             this.appendOutput(`Error: ${this.escapeHtml(data.error)}\n`, 'error');
+        } else if (data.raw) {
+            this.appendRaw(data.output, data.stderr ? 'error' : 'normal');
+        } else if (data.spans) {
+            this.appendOutput(this.renderSpans(data.spans), data.stderr ? 'error' : 'normal');
         } else if (data.output) {
-            this.appendOutput(this.escapeHtml(data.output), 'normal');
+            this.appendOutput(this.escapeHtml(data.output), data.stderr ? 'error' : 'normal');
+        }
+        if (data.type === 'complete' && data.duration !== undefined) {
+            this.appendOutput(this.formatResult(data), 'summary');
+        }
+    }
+
+    // Draws the statistics of a path report in place, until the final
+    // report replaces them
+    showSnapshot(data) {
+        if (!this.snapshot) {
+            this.appendOutput('', 'snapshot');
+            this.snapshot = this.terminalBody.lastChild;
+        }
+
+        const column = (value, width) => String(value).padStart(width);
+        const lines = [
+            `Cycle ${data.cycle}/${data.cycles}`,
+            'Hop  Address                     Loss%   Snt   Last   Avg  Best  Wrst StDev'
+        ];
+        for (const hop of data.hops) {
+            lines.push(
+                column(hop.ttl, 3) + '  ' + (hop.address || '???').padEnd(25) +
+                column(hop.loss.toFixed(1) + '%', 8) + column(hop.sent, 6) +
+                column(hop.last.toFixed(1), 7) + column(hop.avg.toFixed(1), 6) +
+                column(hop.best.toFixed(1), 6) + column(hop.worst.toFixed(1), 6) +
+                column(hop.stdev.toFixed(1), 6)
+            );
+        }
+        this.snapshot.textContent = lines.join('\n');
+        this.terminalBody.scrollTop = this.terminalBody.scrollHeight;
+    }
+
+    formatResult(data) {
+        let text = `Finished in ${data.duration.toFixed(1)}s, `;
+        text += data.signal ? `killed by signal: ${this.escapeHtml(data.signal)}` : `exit ${data.exit_code}`;
+        text += ` (user ${data.user_time.toFixed(2)}s, sys ${data.system_time.toFixed(2)}s`;
+        if (data.max_rss) {
+            text += `, max RSS ${(data.max_rss / 1048576).toFixed(1)} MiB`;
         }
+        return text + ')';
     }
 
     async stopCommand() {
@@ -281,6 +481,7 @@ class YALSClient {
             return;
         }
 
+        this.commandCompleted = true;
         if (this.abortController) {
             this.abortController.abort();
         }
@@ -311,10 +512,66 @@ class YALSClient {
         this.stopBtn.disabled = true;
     }
 
+    showQueueStatus(data) {
+        if (!this.queueStatus) {
+            this.queueStatus = document.createElement('div');
+            this.queueStatus.className = 'terminal-output queued';
+            this.terminalBody.appendChild(this.queueStatus);
+        }
+
+        let text = `Waiting for other commands to finish, position ${data.position} in queue`;
+        if (data.eta) {
+            text += ` (about ${data.eta}s)`;
+        }
+        this.queueStatus.textContent = text;
+    }
+
+    clearQueueStatus() {
+        if (this.queueStatus) {
+            this.queueStatus.remove();
+            this.queueStatus = null;
+        }
+    }
+
+    // Render a chunk of raw output like a terminal would: a carriage return
+    // moves back to the start of the line, which later text overwrites
+    appendRaw(text, type) {
+        for (const part of text.split(/(\r\n|\n|\r)/)) {
+            if (part === '\n' || part === '\r\n') {
+                if (!this.rawLine) {
+                    this.writeRaw('', type);
+                }
+                this.rawLine = null;
+            } else if (part === '\r') {
+                this.rawColumn = 0;
+            } else if (part) {
+                this.writeRaw(part, type);
+            }
+        }
+        this.terminalBody.scrollTop = this.terminalBody.scrollHeight;
+    }
+
+    writeRaw(text, type) {
+        if (!this.rawLine) {
+            this.appendOutput('', type + ' raw');
+            this.rawLine = this.terminalBody.lastElementChild;
+            this.rawText = '';
+            this.rawColumn = 0;
+        }
+
+        this.rawText = this.rawText.slice(0, this.rawColumn) + text + this.rawText.slice(this.rawColumn + text.length);
+        this.rawColumn += text.length;
+        this.rawLine.textContent = this.rawText;
+        if (type === 'error') {
+            this.rawLine.classList.add('error');
+        }
+    }
+
     appendOutput(text, type) {
         if (this.terminalBody.querySelector('.empty-state')) {
             this.terminalBody.innerHTML = '';
         }
+        this.rawLine = null;
 
         const outputDiv = document.createElement('div');
         outputDiv.className = 'terminal-output ' + type;
@@ -326,14 +583,48 @@ class YALSClient {
 
     clearTerminal() {
         this.terminalBody.innerHTML = '';
+        this.queueStatus = null;
+        this.rawLine = null;
+        this.snapshot = null;
     }
 
     disableCommandButtons() {
         this.commandSelector.disabled = true;
+        this.paramsRow.querySelectorAll('.param-input').forEach(input => input.disabled = true);
     }
 
     enableCommandButtons() {
         this.commandSelector.disabled = false;
+        this.paramsRow.querySelectorAll('.param-input').forEach(input => input.disabled = false);
+    }
+
+    // Render styled spans of output. Basic colors map to classes so they
+    // follow the theme, other colors are only used when well formed.
+    renderSpans(spans) {
+        const color = (value, kind, classes, styles) => {
+            if (/^#[0-9a-f]{6}$/.test(value)) {
+                styles.push(`${kind === 'fg' ? 'color' : 'background-color'}: ${value}`);
+            } else if (/^[a-z_]+$/.test(value)) {
+                classes.push(`ansi-${kind}-${value.replace('_', '-')}`);
+            }
+        };
+
+        return spans.map(span => {
+            const classes = [];
+            const styles = [];
+            if (span.fg) color(span.fg, 'fg', classes, styles);
+            if (span.bg) color(span.bg, 'bg', classes, styles);
+            for (const attribute of ['bold', 'dim', 'italic', 'underline']) {
+                if (span[attribute]) classes.push(`ansi-${attribute}`);
+            }
+
+            const text = this.escapeHtml(span.text);
+            if (classes.length === 0 && styles.length === 0) {
+                return text;
+            }
+            const style = styles.length > 0 ? ` style="${styles.join('; ')}"` : '';
+            return `<span class="${classes.join(' ')}"${style}>${text}</span>`;
+        }).join('');
     }
 
     escapeHtml(text) {